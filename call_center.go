@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var callCenterWaitBuckets = []float64{5, 10, 30, 60, 120, 300, 600, 1800}
+
+// Call center collector, based on cc_list_queue, cc_list_flows, cc_list_agents and cc_list_calls
+type callCenterCollector struct {
+	queued     *prometheus.Desc
+	wait       *prometheus.Desc
+	agents     *prometheus.Desc
+	agentCalls *prometheus.Desc
+}
+
+func init() {
+	registerCollector("call-center", "Export the call center queue and agents, listed by cc_list_queue and cc_list_agents.",
+		[]string{"cc_list_queue"}, newCallCenterCollector)
+}
+
+func newCallCenterCollector() (collector, error) {
+	return &callCenterCollector{
+		queued: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "call_center", "queued_calls"),
+			"Number of calls waiting in the queue of the flow",
+			[]string{"flow"},
+			nil,
+		),
+		wait: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "call_center", "queue_wait_seconds"),
+			"Time the queued calls of the flow have been waiting",
+			[]string{"flow"},
+			nil,
+		),
+		agents: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "call_center", "agents"),
+			"Number of agents by skill and state",
+			[]string{"skill", "state"},
+			nil,
+		),
+		agentCalls: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "call_center", "agent_calls"),
+			"Number of ongoing calls by state of their agent",
+			[]string{"state"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *callCenterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queued
+	ch <- c.wait
+	ch <- c.agents
+	ch <- c.agentCalls
+}
+
+// Return the state of a call center agent.
+func callCenterAgentState(node *opensips_mi.MINode) string {
+	if loggedIn := nodeField(node, "logged_in", "loged_in", "Loged in", "Logged in"); loggedIn != "" && parseFlag(loggedIn) == 0 {
+		return "logged_out"
+	}
+	state := strings.ToLower(nodeField(node, "state", "State"))
+	if state == "" {
+		return "unknown"
+	}
+	return state
+}
+
+func (c *callCenterCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	queued := make(map[string]float64)
+	waits := make(map[string]*constHistogram)
+	addFlow := func(flow string) {
+		if _, exists := queued[flow]; !exists {
+			queued[flow] = 0
+			waits[flow] = newConstHistogram(callCenterWaitBuckets)
+		}
+	}
+
+	if t.commands["cc_list_flows"] {
+		// List the flows with no queued calls too
+		resp, err := t.conn.Command("cc_list_flows")
+		if err != nil {
+			return err
+		}
+		for _, node := range childList(resp, "Flow", "Flows") {
+			addFlow(nodeValue(node, "id"))
+		}
+	}
+
+	resp, err := t.conn.Command("cc_list_queue")
+	if err != nil {
+		return err
+	}
+	for _, node := range childList(resp, "Call", "Calls") {
+		flow := nodeField(node, "flow", "Flow")
+		addFlow(flow)
+		queued[flow]++
+		if wait, err := strconv.ParseFloat(nodeField(node, "wait_for", "Waiting for"), 64); err == nil {
+			waits[flow].observe(wait)
+		}
+	}
+
+	for flow, value := range queued {
+		ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, value, flow)
+		ch <- waits[flow].metric(c.wait, flow)
+	}
+
+	if !t.commands["cc_list_agents"] {
+		return nil
+	}
+
+	resp, err = t.conn.Command("cc_list_agents")
+	if err != nil {
+		return err
+	}
+	agentStates := make(map[string]string)
+	agents := newLabelCounts(2)
+	for _, node := range childList(resp, "Agent", "Agents") {
+		state := callCenterAgentState(node)
+		agentStates[nodeValue(node, "id")] = state
+
+		skills := strings.FieldsFunc(nodeField(node, "skills", "Skills"), func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		if len(skills) == 0 {
+			// Versions not listing the skills of the agents
+			skills = []string{""}
+		}
+		for _, skill := range skills {
+			agents.add(1, skill, state)
+		}
+	}
+	for _, value := range agents.top(0) {
+		ch <- prometheus.MustNewConstMetric(c.agents, prometheus.GaugeValue, value.count, value.labels...)
+	}
+
+	if !t.commands["cc_list_calls"] {
+		return nil
+	}
+
+	resp, err = t.conn.Command("cc_list_calls")
+	if err != nil {
+		return err
+	}
+	agentCalls := make(map[string]float64)
+	for _, node := range childList(resp, "Call", "Calls") {
+		agent := nodeField(node, "agent", "Agent")
+		if agent == "" {
+			continue
+		}
+		state, exists := agentStates[agent]
+		if !exists {
+			state = "unknown"
+		}
+		agentCalls[state]++
+	}
+	for state, value := range agentCalls {
+		ch <- prometheus.MustNewConstMetric(c.agentCalls, prometheus.GaugeValue, value, state)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Clusterer topology and capabilities collector, based on clusterer_list,
+// clusterer_list_topology and clusterer_list_cap
+type clustererCollector struct {
+	linkUp     *prometheus.Desc
+	enabled    *prometheus.Desc
+	neighbours *prometheus.Desc
+	synced     *prometheus.Desc
+}
+
+func init() {
+	registerCollector("clusterer", "Export the clusterer nodes, topology and capabilities.",
+		[]string{"clusterer_list"}, newClustererCollector)
+}
+
+func newClustererCollector() (collector, error) {
+	return &clustererCollector{
+		linkUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "clusterer", "node_link_up"),
+			"1 if the link to the cluster node is up",
+			[]string{"cluster", "node", "url", "state"},
+			nil,
+		),
+		enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "clusterer", "node_enabled"),
+			"1 if the cluster node is enabled",
+			[]string{"cluster", "node"},
+			nil,
+		),
+		neighbours: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "clusterer", "node_neighbours"),
+			"Number of neighbours of the cluster node in the cluster topology",
+			[]string{"cluster", "node"},
+			nil,
+		),
+		synced: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "clusterer", "capability_synced"),
+			"1 if the cluster capability is synchronized",
+			[]string{"cluster", "capability", "state"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *clustererCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.linkUp
+	ch <- c.enabled
+	ch <- c.neighbours
+	ch <- c.synced
+}
+
+// Call fn with the id and the named children of every cluster listed by a clusterer MI command.
+func walkClusters(t *target, cmd string, fn func(cluster string, nodes []*opensips_mi.MINode), names ...string) error {
+	resp, err := t.conn.Command(cmd)
+	if err != nil {
+		return err
+	}
+	for _, clusterNode := range childList(resp, "Cluster", "Clusters") {
+		fn(nodeValue(clusterNode, "cluster_id"), childList(clusterNode, names...))
+	}
+	return nil
+}
+
+func (c *clustererCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	err := walkClusters(t, "clusterer_list", func(cluster string, nodes []*opensips_mi.MINode) {
+		for _, node := range nodes {
+			id := nodeValue(node, "node_id")
+			url := nodeField(node, "URL", "url")
+			state := strings.ToLower(nodeField(node, "Link_state", "link_state"))
+
+			up := 0.0
+			if state == "up" {
+				up = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.linkUp, prometheus.GaugeValue, up, cluster, id, url, state)
+			ch <- prometheus.MustNewConstMetric(c.enabled, prometheus.GaugeValue,
+				parseFlag(nodeField(node, "Enabled", "enabled")), cluster, id)
+		}
+	}, "Node", "Nodes")
+	if err != nil {
+		return err
+	}
+
+	if t.commands["clusterer_list_topology"] {
+		err = walkClusters(t, "clusterer_list_topology", func(cluster string, nodes []*opensips_mi.MINode) {
+			for _, node := range nodes {
+				id := nodeValue(node, "node_id")
+
+				// Neighbours are listed either as a list or as a space separated string
+				neighbours := len(strings.Fields(node.Get("Neighbours")))
+				if list := node.Child("Neighbours"); list != nil && len(list.Children) > 0 {
+					neighbours = len(list.Children)
+				}
+				ch <- prometheus.MustNewConstMetric(c.neighbours, prometheus.GaugeValue, float64(neighbours), cluster, id)
+			}
+		}, "Node", "Nodes")
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.commands["clusterer_list_cap"] {
+		err = walkClusters(t, "clusterer_list_cap", func(cluster string, caps []*opensips_mi.MINode) {
+			for _, node := range caps {
+				name := nodeValue(node, "name")
+				state := strings.ToLower(nodeField(node, "State", "state"))
+
+				synced := 0.0
+				if state == "ok" {
+					synced = 1
+				}
+				ch <- prometheus.MustNewConstMetric(c.synced, prometheus.GaugeValue, synced, cluster, name, state)
+			}
+		}, "Capability", "Capabilities")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label value used to aggregate label values beyond the configured limit
+const otherLabelValue = "other"
+
+// The monitored OpenSIPS, as seen by collectors during a scrape
+type target struct {
+	conn     opensips_mi.Client
+	commands map[string]bool
+	version  int
+
+	// Collectors sharing the walks of MI commands, and the outcome of the walks done
+	walkers []walker
+	walked  map[string]error
+}
+
+// Collector walking an MI command listing, which it shares with the other
+// collectors walking the same command during a scrape
+type walker interface {
+	collector
+	// The MI command walked
+	walkCommand() string
+	// Start a new walk, returning the function called with every listed node
+	newWalk() func(*opensips_mi.MINode) error
+}
+
+// Walk the listing of an MI command once per scrape, for all the collectors walking it.
+func (t *target) walk(cmd string) error {
+	if err, done := t.walked[cmd]; done {
+		return err
+	}
+
+	var fns []func(*opensips_mi.MINode) error
+	for _, w := range t.walkers {
+		if w.walkCommand() == cmd {
+			fns = append(fns, w.newWalk())
+		}
+	}
+
+	err := t.stream(func(node *opensips_mi.MINode) error {
+		for _, fn := range fns {
+			if err := fn(node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, cmd)
+
+	if t.walked == nil {
+		t.walked = make(map[string]error)
+	}
+	t.walked[cmd] = err
+	return err
+}
+
+// Return the first of the given MI commands supported by the target, or "" if none is.
+func (t *target) command(cmds ...string) string {
+	for _, cmd := range cmds {
+		if t.commands[cmd] {
+			return cmd
+		}
+	}
+	return ""
+}
+
+// Execute an MI command and call fn with every node of the first list in the
+// response, in document order, streaming it node by node.
+func (t *target) stream(fn func(*opensips_mi.MINode) error, cmd string, args ...string) error {
+	sc, ok := t.conn.(opensips_mi.StreamingClient)
+	if !ok {
+		return fmt.Errorf("%s: the MI client cannot stream responses", cmd)
+	}
+	return sc.CommandStream(fn, cmd, args...)
+}
+
+// Return the elements of a list node, or the node itself if it is not a list.
+func nodeList(node *opensips_mi.MINode) []*opensips_mi.MINode {
+	if node == nil {
+		return nil
+	}
+	if len(node.Children) == 0 && (node.Value != "" || len(node.Attrs) > 0) {
+		return []*opensips_mi.MINode{node}
+	}
+	if len(node.Children) > 0 && node.Children[0].Name != "" {
+		return []*opensips_mi.MINode{node}
+	}
+	return node.Children
+}
+
+// Return the elements of the first of the named list children of a node, or
+// of the node itself if it is one of the named lists.
+func childList(node *opensips_mi.MINode, names ...string) []*opensips_mi.MINode {
+	for _, name := range names {
+		if node.Name == name {
+			return nodeList(node)
+		}
+	}
+	for _, name := range names {
+		if child := node.Child(name); child != nil {
+			return nodeList(child)
+		}
+	}
+	return nil
+}
+
+// Return the first non-empty of the named attributes or children of a node.
+func nodeField(node *opensips_mi.MINode, names ...string) string {
+	for _, name := range names {
+		if value := node.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Return the named attribute or child of a node, falling back to the node
+// value for versions reporting it that way.
+func nodeValue(node *opensips_mi.MINode, name string) string {
+	if value := node.Get(name); value != "" {
+		return value
+	}
+	return node.Value
+}
+
+// Parse the yes/no and on/off flags of MI commands.
+func parseFlag(value string) float64 {
+	switch strings.ToLower(value) {
+	case "yes", "on", "1", "true", "enabled", "active":
+		return 1
+	}
+	return 0
+}
+
+// Optional collector exporting the output of module specific MI commands
+type collector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Collect(t *target, ch chan<- prometheus.Metric) error
+}
+
+// Registered optional collector
+type collectorInfo struct {
+	// MI commands, any of which the target must support
+	commands []string
+	enabled  *bool
+	factory  func() (collector, error)
+}
+
+var collectors = map[string]*collectorInfo{}
+
+// Register an optional collector, enabled with the -collector.<name> flag.
+func registerCollector(name string, help string, commands []string, factory func() (collector, error)) {
+	collectors[name] = &collectorInfo{
+		commands: commands,
+		enabled:  flag.Bool("collector."+name, false, help),
+		factory:  factory,
+	}
+}
+
+// Create the collectors enabled on the command line, keyed by name.
+func enabledCollectors() (map[string]collector, error) {
+	enabled := make(map[string]collector)
+	for name, info := range collectors {
+		if *info.enabled {
+			c, err := info.factory()
+			if err != nil {
+				return nil, fmt.Errorf("collector %s: %s", name, err)
+			}
+			enabled[name] = c
+		}
+	}
+	return enabled, nil
+}
+
+// A set of label values with its count
+type labeledCount struct {
+	labels []string
+	count  float64
+}
+
+// Counts of label value sets, of which only the largest ones are exported
+type labelCounts struct {
+	width  int
+	index  map[string]int
+	counts []labeledCount
+	other  float64
+}
+
+func newLabelCounts(width int) *labelCounts {
+	return &labelCounts{
+		width: width,
+		index: make(map[string]int),
+	}
+}
+
+// Add to the count of a set of label values.
+func (lc *labelCounts) add(count float64, labels ...string) {
+	isOther := len(labels) > 0
+	for _, label := range labels {
+		isOther = isOther && label == otherLabelValue
+	}
+	if isOther {
+		lc.addOther(count)
+		return
+	}
+
+	key := strings.Join(labels, "\xff")
+	if i, exists := lc.index[key]; exists {
+		lc.counts[i].count += count
+		return
+	}
+	lc.index[key] = len(lc.counts)
+	lc.counts = append(lc.counts, labeledCount{labels: labels, count: count})
+}
+
+// Add to the count of the "other" label values.
+func (lc *labelCounts) addOther(count float64) {
+	lc.other += count
+}
+
+// Return the limit largest counts, aggregating the rest with all labels set to "other".
+func (lc *labelCounts) top(limit int) []labeledCount {
+	counts := lc.counts
+	other := lc.other
+	hasOther := other != 0
+
+	if limit > 0 && len(counts) > limit {
+		counts = append([]labeledCount{}, counts...)
+		sort.Slice(counts, func(i, j int) bool {
+			return counts[i].count > counts[j].count
+		})
+		for _, value := range counts[limit:] {
+			other += value.count
+		}
+		counts = counts[:limit]
+		hasOther = true
+	}
+
+	if hasOther {
+		labels := make([]string, lc.width)
+		for i := range labels {
+			labels[i] = otherLabelValue
+		}
+		counts = append(counts, labeledCount{labels: labels, count: other})
+	}
+	return counts
+}
+
+// Histogram of values observed during a scrape
+type constHistogram struct {
+	bounds  []float64
+	buckets map[float64]uint64
+	count   uint64
+	sum     float64
+}
+
+func newConstHistogram(bounds []float64) *constHistogram {
+	buckets := make(map[float64]uint64, len(bounds))
+	for _, bound := range bounds {
+		buckets[bound] = 0
+	}
+	return &constHistogram{bounds: bounds, buckets: buckets}
+}
+
+func (h *constHistogram) observe(value float64) {
+	h.count++
+	h.sum += value
+	for _, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[bound]++
+		}
+	}
+}
+
+func (h *constHistogram) metric(desc *prometheus.Desc, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, h.buckets, labels...)
+}
+
+// Layouts of the dates reported by MI commands
+var timestampLayouts = []string{time.ANSIC, "2006-01-02 15:04:05"}
+
+// Parse a unix timestamp or a local date reported by an MI command.
+func parseTimestamp(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if ts, err := strconv.ParseFloat(value, 64); err == nil {
+		return ts, true
+	}
+	for _, layout := range timestampLayouts {
+		if ts, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return float64(ts.Unix()), true
+		}
+	}
+	return 0, false
+}
+
+var uriSchemes = []string{"sip:", "sips:", "tel:"}
+
+// Return the host part of a SIP URI, like "example.com" for "sip:alice@example.com:5060;transport=tcp".
+func uriDomain(uri string) string {
+	uri = strings.ToLower(uri)
+	if i := strings.Index(uri, "<"); i >= 0 {
+		uri = uri[i+1:]
+	}
+	for _, scheme := range uriSchemes {
+		uri = strings.TrimPrefix(uri, scheme)
+	}
+	if i := strings.LastIndex(uri, "@"); i >= 0 {
+		uri = uri[i+1:]
+	}
+	if i := strings.IndexAny(uri, ";>?"); i >= 0 {
+		uri = uri[:i]
+	}
+	if strings.HasPrefix(uri, "[") {
+		if i := strings.Index(uri, "]"); i >= 0 {
+			return uri[:i+1]
+		}
+	}
+	if i := strings.Index(uri, ":"); i >= 0 {
+		uri = uri[:i]
+	}
+	return uri
+}
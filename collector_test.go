@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Adapter registering an optional collector against a target.
+type targetCollector struct {
+	c collector
+	t *target
+}
+
+func (tc targetCollector) Describe(ch chan<- *prometheus.Desc) {
+	tc.c.Describe(ch)
+}
+
+func (tc targetCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := tc.c.Collect(tc.t, ch); err != nil {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("collector_error", "", nil, nil), err)
+	}
+}
+
+// Create an optional collector, failing on configuration errors.
+func mustCollector(t *testing.T, factory func() (collector, error)) collector {
+	c, err := factory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Serve MI responses by command, or by command and parameters as "cmd?params".
+func newMIServer(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if params := r.URL.Query().Get("params"); params != "" {
+			key += "?" + params
+		}
+		if resp, exists := responses[key]; exists {
+			fmt.Fprint(w, resp)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// Gather the metrics of an optional collector from a target serving the
+// given MI responses, failing on errors and inconsistent metrics.
+func gatherCollector(t *testing.T, c collector, responses map[string]string) map[string]float64 {
+	srv := newMIServer(responses)
+	defer srv.Close()
+
+	conn, err := opensips_mi.NewMIJsonClient(srv.URL, opensips_mi.MIJsonConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := make(map[string]bool)
+	for key := range responses {
+		commands[strings.SplitN(key, "?", 2)[0]] = true
+	}
+	tg := &target{conn: conn, commands: commands}
+	if w, ok := c.(walker); ok {
+		tg.walkers = []walker{w}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(targetCollector{c, tg})
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.Metric {
+			labels := make([]string, 0, len(m.Label))
+			for _, label := range m.Label {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			sort.Strings(labels)
+			key := family.GetName() + "{" + strings.Join(labels, ",") + "}"
+			switch {
+			case m.Counter != nil:
+				metrics[key] = m.Counter.GetValue()
+			case m.Gauge != nil:
+				metrics[key] = m.Gauge.GetValue()
+			case m.Untyped != nil:
+				metrics[key] = m.Untyped.GetValue()
+			case m.Histogram != nil:
+				metrics[key] = float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return metrics
+}
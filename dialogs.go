@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dialogsStuckThreshold = flag.Duration("collector.dialogs.stuck-threshold", 4*time.Hour,
+		"Age after which a dialog is considered stuck.")
+	dialogsDomainsLimit = flag.Int("collector.dialogs.domains-limit", 20,
+		"Maximum number of caller and callee domains to export. 0 disables the limit.")
+)
+
+// Dialog states, as numbered by the dialog module
+var dialogStates = map[string]string{
+	"1": "unconfirmed",
+	"2": "early",
+	"3": "confirmed_not_acked",
+	"4": "confirmed",
+	"5": "deleted",
+}
+
+var dialogDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 14400}
+
+// Collector of the individual dialogs listed by dlg_list
+type dialogsCollector struct {
+	byState       *prometheus.Desc
+	duration      *prometheus.Desc
+	callerDomains *prometheus.Desc
+	calleeDomains *prometheus.Desc
+	stuck         *prometheus.Desc
+}
+
+func init() {
+	registerCollector("dialogs", "Export details of the active dialogs, walking dlg_list.",
+		[]string{"dlg_list"}, newDialogsCollector)
+}
+
+func newDialogsCollector() (collector, error) {
+	return &dialogsCollector{
+		byState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", "dialogs_by_state"),
+			"Number of dialogs by state",
+			[]string{"state"},
+			nil,
+		),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", "duration_seconds"),
+			"Current duration of the started dialogs",
+			nil,
+			nil,
+		),
+		callerDomains: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", "caller_domain_dialogs"),
+			"Number of dialogs by caller domain",
+			[]string{"domain"},
+			nil,
+		),
+		calleeDomains: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", "callee_domain_dialogs"),
+			"Number of dialogs by callee domain",
+			[]string{"domain"},
+			nil,
+		),
+		stuck: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", "stuck_dialogs"),
+			"Number of dialogs older than the stuck threshold",
+			nil,
+			nil,
+		),
+	}, nil
+}
+
+func (c *dialogsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.byState
+	ch <- c.duration
+	ch <- c.callerDomains
+	ch <- c.calleeDomains
+	ch <- c.stuck
+}
+
+func (c *dialogsCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	now := float64(time.Now().Unix())
+	threshold := dialogsStuckThreshold.Seconds()
+
+	states := make(map[string]float64, len(dialogStates))
+	for _, state := range dialogStates {
+		states[state] = 0
+	}
+	callerDomains := newLabelCounts(1)
+	calleeDomains := newLabelCounts(1)
+	durations := newConstHistogram(dialogDurationBuckets)
+	var stuck float64
+
+	err := t.stream(func(node *opensips_mi.MINode) error {
+		if state, exists := dialogStates[node.Get("state")]; exists {
+			states[state]++
+		}
+
+		callerDomains.add(1, uriDomain(node.Get("from_uri")))
+		calleeDomains.add(1, uriDomain(node.Get("to_uri")))
+
+		start, err := strconv.ParseFloat(node.Get("timestart"), 64)
+		if err != nil || start <= 0 {
+			// Not started yet
+			return nil
+		}
+		duration := now - start
+		durations.observe(duration)
+		if duration > threshold {
+			stuck++
+		}
+		return nil
+	}, "dlg_list")
+	if err != nil {
+		return err
+	}
+
+	for state, value := range states {
+		ch <- prometheus.MustNewConstMetric(c.byState, prometheus.GaugeValue, value, state)
+	}
+	ch <- durations.metric(c.duration)
+	ch <- prometheus.MustNewConstMetric(c.stuck, prometheus.GaugeValue, stuck)
+
+	for _, value := range callerDomains.top(*dialogsDomainsLimit) {
+		ch <- prometheus.MustNewConstMetric(c.callerDomains, prometheus.GaugeValue, value.count, value.labels...)
+	}
+	for _, value := range calleeDomains.top(*dialogsDomainsLimit) {
+		ch <- prometheus.MustNewConstMetric(c.calleeDomains, prometheus.GaugeValue, value.count, value.labels...)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Dispatcher destination states, by their name or flag
+var dispatcherStates = map[string]string{
+	"active":   "active",
+	"a":        "active",
+	"inactive": "inactive",
+	"i":        "inactive",
+	"probing":  "probing",
+	"p":        "probing",
+	"disabled": "disabled",
+	"d":        "disabled",
+}
+
+// Dispatcher destination, merging the entries of a set with the same URI
+type dispatcherDestination struct {
+	state       string
+	weight      float64
+	priority    float64
+	hasWeight   bool
+	hasPriority bool
+}
+
+// Dispatcher destinations collector, based on ds_list
+type dispatcherCollector struct {
+	up           *prometheus.Desc
+	weight       *prometheus.Desc
+	priority     *prometheus.Desc
+	active       *prometheus.Desc
+	destinations *prometheus.Desc
+}
+
+func init() {
+	registerCollector("dispatcher", "Export the state of the dispatcher destinations, listed by ds_list.",
+		[]string{"ds_list"}, newDispatcherCollector)
+}
+
+func newDispatcherCollector() (collector, error) {
+	return &dispatcherCollector{
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dispatcher", "destination_up"),
+			"1 if the dispatcher destination is active",
+			[]string{"partition", "set", "uri", "state"},
+			nil,
+		),
+		weight: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dispatcher", "destination_weight"),
+			"Weight of the dispatcher destination, summed over the entries with its URI",
+			[]string{"partition", "set", "uri"},
+			nil,
+		),
+		priority: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dispatcher", "destination_priority"),
+			"Priority of the first entry of the dispatcher destination with its URI",
+			[]string{"partition", "set", "uri"},
+			nil,
+		),
+		active: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dispatcher", "set_active_destinations"),
+			"Number of active destinations in the dispatcher set",
+			[]string{"partition", "set"},
+			nil,
+		),
+		destinations: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dispatcher", "set_destinations"),
+			"Number of destinations in the dispatcher set",
+			[]string{"partition", "set"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *dispatcherCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.weight
+	ch <- c.priority
+	ch <- c.active
+	ch <- c.destinations
+}
+
+// Return the state of a dispatcher destination, from its state name or flags.
+func dispatcherState(node *opensips_mi.MINode) string {
+	state := node.Get("state")
+	if state == "" {
+		state = node.Get("flags")
+	}
+	state = strings.ToLower(state)
+	if s, exists := dispatcherStates[state]; exists {
+		return s
+	}
+	if len(state) > 0 {
+		if s, exists := dispatcherStates[state[:1]]; exists {
+			return s
+		}
+	}
+	return "unknown"
+}
+
+func (c *dispatcherCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	// Ask for the full listing, including weights and priorities
+	resp, err := t.conn.Command("ds_list", "full")
+	if err != nil {
+		if resp, err = t.conn.Command("ds_list"); err != nil {
+			return err
+		}
+	}
+
+	partitions := childList(resp, "PARTITION", "PARTITIONS")
+	if partitions == nil {
+		// Versions without partitions list the sets directly
+		partitions = []*opensips_mi.MINode{resp}
+	}
+
+	for _, partitionNode := range partitions {
+		partition := nodeValue(partitionNode, "name")
+		if partition == "" {
+			partition = "default"
+		}
+
+		for _, setNode := range childList(partitionNode, "SET", "SETS") {
+			set := nodeValue(setNode, "id")
+
+			// A set may list the same URI more than once, its entries are
+			// exported as one destination, active if any of them is
+			var uris []string
+			destinations := make(map[string]*dispatcherDestination)
+			for _, node := range childList(setNode, "URI", "Destinations") {
+				uri := nodeValue(node, "URI")
+				state := dispatcherState(node)
+
+				dest, exists := destinations[uri]
+				if !exists {
+					dest = &dispatcherDestination{state: state}
+					if priority, err := strconv.ParseFloat(node.Get("priority"), 64); err == nil {
+						dest.priority, dest.hasPriority = priority, true
+					}
+					destinations[uri] = dest
+					uris = append(uris, uri)
+				} else if state == "active" {
+					dest.state = state
+				}
+				if weight, err := strconv.ParseFloat(node.Get("weight"), 64); err == nil {
+					dest.weight += weight
+					dest.hasWeight = true
+				}
+			}
+
+			var active float64
+			for _, uri := range uris {
+				dest := destinations[uri]
+				up := 0.0
+				if dest.state == "active" {
+					up = 1
+					active++
+				}
+				ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up, partition, set, uri, dest.state)
+
+				if dest.hasWeight {
+					ch <- prometheus.MustNewConstMetric(c.weight, prometheus.GaugeValue, dest.weight, partition, set, uri)
+				}
+				if dest.hasPriority {
+					ch <- prometheus.MustNewConstMetric(c.priority, prometheus.GaugeValue, dest.priority, partition, set, uri)
+				}
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, active, partition, set)
+			ch <- prometheus.MustNewConstMetric(c.destinations, prometheus.GaugeValue, float64(len(uris)), partition, set)
+		}
+	}
+
+	return nil
+}
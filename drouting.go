@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var droutingPartitions = flag.String("collector.drouting.partitions", "",
+	"Comma separated list of drouting partitions to export. Empty when drouting does not use partitions.")
+
+// Dynamic routing gateways and carriers collector, based on dr_gw_status and dr_carrier_status
+type droutingCollector struct {
+	partitions []string
+
+	gatewayUp      *prometheus.Desc
+	gatewayProbing *prometheus.Desc
+	carrierEnabled *prometheus.Desc
+	reloadTime     *prometheus.Desc
+}
+
+func init() {
+	registerCollector("drouting", "Export the state of the dynamic routing gateways and carriers.",
+		[]string{"dr_gw_status"}, newDroutingCollector)
+}
+
+func newDroutingCollector() (collector, error) {
+	partitions := []string{""}
+	if *droutingPartitions != "" {
+		partitions = strings.Split(*droutingPartitions, ",")
+	}
+
+	return &droutingCollector{
+		partitions: partitions,
+
+		gatewayUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "drouting", "gateway_up"),
+			"1 if the dynamic routing gateway is active",
+			[]string{"partition", "gateway", "address", "state"},
+			nil,
+		),
+		gatewayProbing: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "drouting", "gateway_probing"),
+			"1 if the dynamic routing gateway is being probed",
+			[]string{"partition", "gateway", "address"},
+			nil,
+		),
+		carrierEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "drouting", "carrier_enabled"),
+			"1 if the dynamic routing carrier is enabled",
+			[]string{"partition", "carrier"},
+			nil,
+		),
+		reloadTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "drouting", "last_reload_timestamp_seconds"),
+			"Time of the last dynamic routing data reload since unix epoch in seconds",
+			[]string{"partition"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *droutingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.gatewayUp
+	ch <- c.gatewayProbing
+	ch <- c.carrierEnabled
+	ch <- c.reloadTime
+}
+
+// Run a drouting MI command for a partition, if any.
+func droutingCommand(t *target, cmd string, partition string) (*opensips_mi.MINode, error) {
+	if partition == "" {
+		return t.conn.Command(cmd)
+	}
+	return t.conn.Command(cmd, partition)
+}
+
+func (c *droutingCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	for _, partition := range c.partitions {
+		label := partition
+		if label == "" {
+			label = "default"
+		}
+
+		resp, err := droutingCommand(t, "dr_gw_status", partition)
+		if err != nil {
+			return err
+		}
+		for _, node := range childList(resp, "ID", "Gateways") {
+			gateway := nodeValue(node, "ID")
+			address := node.Get("IP")
+			state := strings.ToLower(node.Get("State"))
+
+			up := 0.0
+			if state == "active" {
+				up = 1
+			}
+			probing := 0.0
+			if strings.Contains(state, "probing") {
+				probing = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.gatewayUp, prometheus.GaugeValue, up, label, gateway, address, state)
+			ch <- prometheus.MustNewConstMetric(c.gatewayProbing, prometheus.GaugeValue, probing, label, gateway, address)
+		}
+
+		if t.commands["dr_carrier_status"] {
+			resp, err = droutingCommand(t, "dr_carrier_status", partition)
+			if err != nil {
+				return err
+			}
+			for _, node := range childList(resp, "ID", "Carriers") {
+				carrier := nodeValue(node, "ID")
+				enabled := node.Get("Enabled")
+				if enabled == "" {
+					enabled = node.Get("State")
+				}
+				ch <- prometheus.MustNewConstMetric(c.carrierEnabled, prometheus.GaugeValue, parseFlag(enabled), label, carrier)
+			}
+		}
+
+		if t.commands["dr_reload_status"] {
+			resp, err = droutingCommand(t, "dr_reload_status", partition)
+			if err != nil {
+				return err
+			}
+			if ts, ok := parseTimestamp(resp.Get("Date")); ok {
+				ch <- prometheus.MustNewConstMetric(c.reloadTime, prometheus.GaugeValue, ts, label)
+			}
+		}
+	}
+
+	return nil
+}
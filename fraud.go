@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fraudConfigPath = flag.String("collector.fraud.config", "",
+	"Path to the JSON file with the fraud detection profiles, users and prefixes to export.")
+
+// Users and prefixes whose statistics are exported for a fraud detection profile
+type fraudProfileConfig struct {
+	Users    []string `json:"users"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// Fraud detection configuration
+type fraudConfig struct {
+	// Per-profile configuration, keyed by profile id
+	Profiles map[string]*fraudProfileConfig `json:"profiles"`
+}
+
+// Load the fraud detection configuration from a JSON file.
+func loadFraudConfig(path string) (*fraudConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &fraudConfig{}
+	if err = json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for profile, pc := range config.Profiles {
+		if pc == nil || len(pc.Users) == 0 || len(pc.Prefixes) == 0 {
+			return nil, fmt.Errorf("fraud profile %s: no users or prefixes", profile)
+		}
+		if dup := duplicateString(pc.Users); dup != "" {
+			return nil, fmt.Errorf("fraud profile %s: duplicate user %s", profile, dup)
+		}
+		if dup := duplicateString(pc.Prefixes); dup != "" {
+			return nil, fmt.Errorf("fraud profile %s: duplicate prefix %s", profile, dup)
+		}
+	}
+	return config, nil
+}
+
+// Return the first string listed twice, or "" if there is none.
+func duplicateString(values []string) string {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			return value
+		}
+		seen[value] = true
+	}
+	return ""
+}
+
+// Statistics reported by show_fraud_stats
+var fraudStats = []struct {
+	field string
+	name  string
+	help  string
+}{
+	{"cpm", "calls_per_minute", "Number of calls per minute of the user to the prefix"},
+	{"total_calls", "calls", "Number of calls of the user to the prefix in the current interval"},
+	{"concurrent_calls", "concurrent_calls", "Number of concurrent calls of the user to the prefix"},
+	{"seq_calls", "sequential_calls", "Number of sequential calls of the user to the prefix"},
+}
+
+// Fraud detection statistics collector, based on show_fraud_stats
+type fraudCollector struct {
+	config *fraudConfig
+	descs  []*prometheus.Desc
+}
+
+// MI error of show_fraud_stats for a user and prefix without statistics
+var fraudNoStatsRegexp = regexp.MustCompile(`(?i)^mi_json error: .*\bno (data|stats)\b`)
+
+func init() {
+	registerCollector("fraud", "Export the fraud detection statistics of the users configured with -collector.fraud.config.",
+		[]string{"show_fraud_stats"}, newFraudCollector)
+}
+
+func newFraudCollector() (collector, error) {
+	if *fraudConfigPath == "" {
+		return nil, errors.New("-collector.fraud.config is required")
+	}
+	config, err := loadFraudConfig(*fraudConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading fraud detection configuration: %s", err)
+	}
+
+	c := &fraudCollector{config: config}
+	for _, stat := range fraudStats {
+		c.descs = append(c.descs, prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fraud", stat.name),
+			stat.help,
+			[]string{"profile", "user", "prefix"},
+			nil,
+		))
+	}
+	return c, nil
+}
+
+func (c *fraudCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+func (c *fraudCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	for profile, pc := range c.config.Profiles {
+		for _, user := range pc.Users {
+			for _, prefix := range pc.Prefixes {
+				resp, err := t.conn.Command("show_fraud_stats", profile, user, prefix)
+				if err != nil {
+					// There are no statistics until the user calls the prefix
+					if fraudNoStatsRegexp.MatchString(err.Error()) {
+						continue
+					}
+					return err
+				}
+
+				for i, stat := range fraudStats {
+					if value, err := strconv.ParseFloat(resp.Get(stat.field), 64); err == nil {
+						ch <- prometheus.MustNewConstMetric(c.descs[i], prometheus.GaugeValue, value, profile, user, prefix)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Write a fraud configuration to a temporary file, returning its path.
+func writeFraudConfig(t *testing.T, config string) string {
+	f, err := ioutil.TempFile("", "fraud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(config); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadFraudConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			config: `{"profiles": {"1": {"users": ["alice", "bob"], "prefixes": ["40", "44"]}}}`,
+		},
+		{
+			name:    "no prefixes",
+			config:  `{"profiles": {"1": {"users": ["alice"]}}}`,
+			wantErr: "fraud profile 1: no users or prefixes",
+		},
+		{
+			name:    "duplicate users",
+			config:  `{"profiles": {"1": {"users": ["alice", "bob", "alice"], "prefixes": ["40"]}}}`,
+			wantErr: "fraud profile 1: duplicate user alice",
+		},
+		{
+			name:    "duplicate prefixes",
+			config:  `{"profiles": {"1": {"users": ["alice"], "prefixes": ["40", "40"]}}}`,
+			wantErr: "fraud profile 1: duplicate prefix 40",
+		},
+		{
+			name:    "invalid JSON",
+			config:  `{"profiles": [`,
+			wantErr: "unexpected EOF",
+		},
+	}
+	for _, test := range tests {
+		path := writeFraudConfig(t, test.config)
+		_, err := loadFraudConfig(path)
+		os.Remove(path)
+
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestNewFraudCollectorWithoutConfig(t *testing.T) {
+	prev := *fraudConfigPath
+	defer func() { *fraudConfigPath = prev }()
+
+	*fraudConfigPath = ""
+	if _, err := newFraudCollector(); err == nil {
+		t.Error("got no error without a configuration")
+	}
+}
+
+func TestFraudCollector(t *testing.T) {
+	prev := *fraudConfigPath
+	defer func() { *fraudConfigPath = prev }()
+
+	*fraudConfigPath = writeFraudConfig(t, `{"profiles": {"1": {"users": ["alice", "bob"], "prefixes": ["40"]}}}`)
+	defer os.Remove(*fraudConfigPath)
+	c := mustCollector(t, newFraudCollector)
+
+	tests := []struct {
+		name      string
+		responses map[string]string
+		want      map[string]float64
+		wantErr   bool
+	}{
+		{
+			name: "users without statistics are skipped",
+			responses: map[string]string{
+				"show_fraud_stats?1,alice,40": `{"cpm": 2, "total_calls": 10, "concurrent_calls": 1, "seq_calls": 3}`,
+				"show_fraud_stats?1,bob,40":   `{"error": {"code": 400, "message": "No data for this user/prefix"}}`,
+			},
+			want: map[string]float64{
+				`opensips_fraud_calls_per_minute{prefix=40,profile=1,user=alice}`: 2,
+				`opensips_fraud_calls{prefix=40,profile=1,user=alice}`:            10,
+				`opensips_fraud_concurrent_calls{prefix=40,profile=1,user=alice}`: 1,
+				`opensips_fraud_sequential_calls{prefix=40,profile=1,user=alice}`: 3,
+			},
+		},
+		{
+			name: "other MI errors",
+			responses: map[string]string{
+				"show_fraud_stats?1,alice,40": `{"error": {"code": 400, "message": "Unknown profile"}}`,
+				"show_fraud_stats?1,bob,40":   `{"error": {"code": 400, "message": "Unknown profile"}}`,
+			},
+			wantErr: true,
+		},
+		{
+			name:      "HTTP errors",
+			responses: map[string]string{},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		srv := newMIServer(test.responses)
+		conn, err := opensips_mi.NewMIJsonClient(srv.URL, opensips_mi.MIJsonConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan prometheus.Metric, 100)
+		err = c.Collect(&target{conn: conn}, ch)
+		srv.Close()
+		close(ch)
+
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		metrics := make(map[string]float64)
+		for metric := range ch {
+			key, value := metricKey(t, metric)
+			metrics[key] = value
+		}
+		for key, want := range test.want {
+			if got, exists := metrics[key]; !exists || got != want {
+				t.Errorf("%s: %s = %v, want %v", test.name, key, got, want)
+			}
+		}
+		if len(metrics) != len(test.want) {
+			t.Errorf("%s: got %d metrics, want %d", test.name, len(metrics), len(test.want))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Load balancer resources collector, based on lb_list
+type loadBalancerCollector struct {
+	load    *prometheus.Desc
+	max     *prometheus.Desc
+	enabled *prometheus.Desc
+	probing *prometheus.Desc
+}
+
+func init() {
+	registerCollector("load-balancer", "Export the load of the load balancer destinations, listed by lb_list.",
+		[]string{"lb_list"}, newLoadBalancerCollector)
+}
+
+func newLoadBalancerCollector() (collector, error) {
+	return &loadBalancerCollector{
+		load: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "lb", "resource_load"),
+			"Current load of the resource on the load balancer destination",
+			[]string{"group", "id", "uri", "resource"},
+			nil,
+		),
+		max: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "lb", "resource_load_max"),
+			"Maximum load of the resource on the load balancer destination",
+			[]string{"group", "id", "uri", "resource"},
+			nil,
+		),
+		enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "lb", "destination_enabled"),
+			"1 if the load balancer destination is enabled",
+			[]string{"group", "id", "uri"},
+			nil,
+		),
+		probing: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "lb", "destination_probing"),
+			"1 if the load balancer destination is automatically re-enabled by probing",
+			[]string{"group", "id", "uri"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *loadBalancerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.load
+	ch <- c.max
+	ch <- c.enabled
+	ch <- c.probing
+}
+
+func (c *loadBalancerCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command("lb_list")
+	if err != nil {
+		return err
+	}
+
+	for _, node := range childList(resp, "Destination", "Destinations") {
+		// A group may hold the same URI more than once, under different ids
+		group := node.Get("group")
+		id := node.Get("id")
+		uri := node.Get("uri")
+		if uri == "" {
+			uri = node.Value
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.enabled, prometheus.GaugeValue, parseFlag(node.Get("enabled")), group, id, uri)
+		ch <- prometheus.MustNewConstMetric(c.probing, prometheus.GaugeValue, parseFlag(node.Get("auto-re")), group, id, uri)
+
+		resources := node.Child("Resources")
+		if resources == nil {
+			continue
+		}
+		list := childList(resources, "Resource")
+		if list == nil {
+			list = nodeList(resources)
+		}
+		for _, res := range list {
+			name := nodeValue(res, "name")
+			if load, err := strconv.ParseFloat(res.Get("load"), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, load, group, id, uri, name)
+			}
+			if max, err := strconv.ParseFloat(res.Get("max"), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, max, group, id, uri, name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadBalancerCollector(t *testing.T) {
+	responses := map[string]string{
+		"2.x": `{"Destination": [
+			{"value": "sip:10.0.0.1", "attributes": {"id": "1", "group": "1", "enabled": "yes", "auto-re": "on"},
+				"children": {"Resources": {"children": {"Resource": {"value": "pstn", "attributes": {"load": "2", "max": "10"}}}}}},
+			{"value": "sip:10.0.0.1", "attributes": {"id": "2", "group": "1", "enabled": "no", "auto-re": "off"},
+				"children": {"Resources": {"children": {"Resource": {"value": "pstn", "attributes": {"load": "0", "max": "5"}}}}}}
+		]}`,
+		"3.x": `{"Destinations": [
+			{"id": 1, "group": 1, "uri": "sip:10.0.0.1", "enabled": "yes", "auto-re": "on",
+				"Resources": [{"name": "pstn", "load": 2, "max": 10}]},
+			{"id": 2, "group": 1, "uri": "sip:10.0.0.1", "enabled": "no", "auto-re": "off",
+				"Resources": [{"name": "pstn", "load": 0, "max": 5}]}
+		]}`,
+	}
+	want := map[string]float64{
+		`opensips_lb_destination_enabled{group=1,id=1,uri=sip:10.0.0.1}`:             1,
+		`opensips_lb_destination_enabled{group=1,id=2,uri=sip:10.0.0.1}`:             0,
+		`opensips_lb_destination_probing{group=1,id=1,uri=sip:10.0.0.1}`:             1,
+		`opensips_lb_destination_probing{group=1,id=2,uri=sip:10.0.0.1}`:             0,
+		`opensips_lb_resource_load{group=1,id=1,resource=pstn,uri=sip:10.0.0.1}`:     2,
+		`opensips_lb_resource_load{group=1,id=2,resource=pstn,uri=sip:10.0.0.1}`:     0,
+		`opensips_lb_resource_load_max{group=1,id=1,resource=pstn,uri=sip:10.0.0.1}`: 10,
+		`opensips_lb_resource_load_max{group=1,id=2,resource=pstn,uri=sip:10.0.0.1}`: 5,
+	}
+
+	for version, resp := range responses {
+		got := gatherCollector(t, mustCollector(t, newLoadBalancerCollector), map[string]string{"lb_list": resp})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", version, got, want)
+		}
+	}
+}
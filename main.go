@@ -10,49 +10,155 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tavyc/opensips_exporter/opensips_mi"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/procfs"
 )
 
 const namespace = "opensips"
 
+// Exporter options
+type exporterOptions struct {
+	cacheTTL         time.Duration
+	profiles         *profilesConfig
+	profileTotalSize bool
+	procfs           *procfs.FS
+	joinProcesses    bool
+	collectors       map[string]collector
+}
+
 // OpensSIPS Prometheus exporter
 type opensipsExporter struct {
-	url string
-
-	mu         sync.RWMutex
-	commands   map[string]bool
-	processes  [][]string
-	profiles   map[string]bool
-	lastUptime float64
+	url              string
+	cacheTTL         time.Duration
+	profilesConfig   *profilesConfig
+	profileTotalSize bool
+	procfs           *procfs.FS
+	joinProcesses    bool
+	collectors       map[string]collector
+
+	mu            sync.RWMutex
+	commands      map[string]bool
+	processes     []process
+	profiles      map[string]bool
+	mainPid       string
+	lastStartTime float64
+	cachedAt      time.Time
+	version       int
+
+	flightMu sync.Mutex
+	inflight *scrapeCall
+
+	scrapesCoalesced prometheus.Counter
+	restarts         prometheus.Counter
 
 	up                 *prometheus.Desc
+	startTime          *prometheus.Desc
 	versionInfo        *prometheus.Desc
 	processInfo        *prometheus.Desc
+	processCPU         *prometheus.Desc
+	processMemory      *prometheus.Desc
+	processFDs         *prometheus.Desc
 	profilesValuesInfo *prometheus.Desc
+	profileSize        *prometheus.Desc
+	collectorSuccess   *prometheus.Desc
+	collectorDuration  *prometheus.Desc
+}
+
+// A scrape of the OpenSIPS target, shared between concurrent collections.
+type scrapeCall struct {
+	done    chan struct{}
+	metrics []prometheus.Metric
 }
 
 func (ose *opensipsExporter) Describe(ch chan<- *prometheus.Desc) {
+	ose.scrapesCoalesced.Describe(ch)
+	ose.restarts.Describe(ch)
 	ch <- ose.up
+	ch <- ose.startTime
 	ch <- ose.versionInfo
 	ch <- ose.processInfo
+	if ose.procfs != nil {
+		ch <- ose.processCPU
+		ch <- ose.processMemory
+		ch <- ose.processFDs
+	}
 	ch <- ose.profilesValuesInfo
+	ch <- ose.profileSize
+
+	for _, config := range ose.profilesConfig.Profiles {
+		if config.desc != nil {
+			ch <- config.desc
+		}
+	}
+
+	ch <- ose.collectorSuccess
+	ch <- ose.collectorDuration
+	for _, c := range ose.collectors {
+		c.Describe(ch)
+	}
 
 	for _, stats := range opensipsStats {
 		for _, stat := range stats {
-			ch <- stat.desc
+			if ose.joinProcesses && stat.joinedDesc != nil {
+				ch <- stat.joinedDesc
+				ch <- stat.roleDesc
+			} else {
+				ch <- stat.desc
+			}
 		}
 	}
 }
 
 func (ose *opensipsExporter) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range ose.scrape() {
+		ch <- metric
+	}
+	ose.scrapesCoalesced.Collect(ch)
+}
+
+// Run a single MI round against OpenSIPS and return the collected metrics.
+// Concurrent callers wait for the scrape already in flight instead of
+// starting their own.
+func (ose *opensipsExporter) scrape() []prometheus.Metric {
+	ose.flightMu.Lock()
+	if call := ose.inflight; call != nil {
+		ose.flightMu.Unlock()
+		ose.scrapesCoalesced.Inc()
+		<-call.done
+		return call.metrics
+	}
+	call := &scrapeCall{done: make(chan struct{})}
+	ose.inflight = call
+	ose.flightMu.Unlock()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		ose.collect(ch)
+		close(ch)
+	}()
+	for metric := range ch {
+		call.metrics = append(call.metrics, metric)
+	}
+
+	ose.flightMu.Lock()
+	ose.inflight = nil
+	ose.flightMu.Unlock()
+	close(call.done)
+
+	return call.metrics
+}
+
+func (ose *opensipsExporter) collect(ch chan<- prometheus.Metric) {
 	up := 0
 
 	defer (func() {
 		ch <- prometheus.MustNewConstMetric(ose.up, prometheus.GaugeValue, float64(up))
+		ose.restarts.Collect(ch)
 	})()
 
 	conn, err := opensips_mi.NewMIJsonClient(ose.url, opensips_mi.MIJsonConfig{})
@@ -69,9 +175,19 @@ func (ose *opensipsExporter) Collect(ch chan<- prometheus.Metric) {
 	var uptime float64
 	up = 1
 
+	// Refresh our caches periodically, even if the target did not restart
+	ose.mu.RLock()
+	expired := ose.cacheTTL > 0 && time.Since(ose.cachedAt) > ose.cacheTTL
+	ose.mu.RUnlock()
+
+	if expired {
+		ose.invalidateCaches()
+	}
+
+	pidChanged := ose.collectProcessInfo(conn, ch)
+
 	ose.mu.RLock()
 	hasCommands := len(ose.commands) > 0
-	hasProcesses := len(ose.processes) > 0
 	hasProfiles := len(ose.profiles) > 0
 	ose.mu.RUnlock()
 
@@ -84,29 +200,91 @@ func (ose *opensipsExporter) Collect(ch chan<- prometheus.Metric) {
 	hasProfilesCommand := ose.commands["list_all_profiles"]
 	ose.mu.RUnlock()
 
-	ose.collectProcessInfo(conn, ch, !hasProcesses)
+	sent := time.Now()
 	if hasStatisticsCommand {
 		uptime = ose.collectStats(conn, ch)
 	}
+	ose.collectRestarts(uptime, sent, pidChanged, ch)
 	if hasProfilesCommand {
 		ose.collectDialogProfiles(conn, ch, !hasProfiles)
 	}
 
-	// Invalidate our caches when the monitored target restarts
 	ose.mu.RLock()
-	restart := uptime < ose.lastUptime
+	t := &target{conn: conn, commands: ose.commands, version: ose.version}
 	ose.mu.RUnlock()
 
-	if restart {
+	enabled := make(map[string]collector, len(ose.collectors))
+	for name, c := range ose.collectors {
+		if t.command(collectors[name].commands...) != "" {
+			enabled[name] = c
+			if w, ok := c.(walker); ok {
+				t.walkers = append(t.walkers, w)
+			}
+		}
+	}
+	for name, c := range enabled {
+		ose.runCollector(name, c, t, ch)
+	}
+}
+
+// Run an optional collector, exporting whether it succeeded and how long it took.
+func (ose *opensipsExporter) runCollector(name string, c collector, t *target, ch chan<- prometheus.Metric) {
+	start := time.Now()
+	err := c.Collect(t, ch)
+	duration := time.Since(start).Seconds()
+
+	success := 1.0
+	if err != nil {
+		log.Printf("error collecting %s: %s", name, err)
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(ose.collectorSuccess, prometheus.GaugeValue, success, name)
+	ch <- prometheus.MustNewConstMetric(ose.collectorDuration, prometheus.GaugeValue, duration, name)
+}
+
+// Forget everything we learned about the monitored target.
+func (ose *opensipsExporter) invalidateCaches() {
+	ose.mu.Lock()
+	ose.commands = make(map[string]bool)
+	ose.processes = nil
+	ose.profiles = make(map[string]bool)
+	ose.cachedAt = time.Now()
+	ose.mu.Unlock()
+}
+
+// Detect restarts of the monitored target from its start time, derived from
+// its uptime requested at sent, or from a new main process PID, and export
+// its start time.
+func (ose *opensipsExporter) collectRestarts(uptime float64, sent time.Time, pidChanged bool, ch chan<- prometheus.Metric) {
+	restart := pidChanged
+
+	if uptime > 0 {
+		// The uptime was taken between sending the request and now, so the
+		// start time is at least earliest and at most startTime
+		startTime := float64(time.Now().UnixNano())/1e9 - uptime
+		earliest := float64(sent.UnixNano())/1e9 - uptime
+
+		// The uptime is reported in whole seconds, so the start time jitters
 		ose.mu.Lock()
-		ose.commands = make(map[string]bool)
-		ose.processes = nil
-		ose.profiles = make(map[string]bool)
-		ose.lastUptime = uptime
+		if ose.lastStartTime > 0 && earliest > ose.lastStartTime+startTimeJitter {
+			restart = true
+		}
+		ose.lastStartTime = startTime
 		ose.mu.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(ose.startTime, prometheus.GaugeValue, startTime)
+	}
+
+	// Invalidate our caches when the monitored target restarts
+	if restart {
+		ose.restarts.Inc()
+		ose.invalidateCaches()
 	}
 }
 
+// Seconds the start time derived from the uptime may move forward without a restart
+const startTimeJitter = 2
+
 var versionRegexp = regexp.MustCompile(`(\S+)\s+\((\S+)\s+\((\S+)/(\S+)\)\)`)
 
 func (ose *opensipsExporter) collectVersionInfo(conn opensips_mi.Client, ch chan<- prometheus.Metric) error {
@@ -117,10 +295,27 @@ func (ose *opensipsExporter) collectVersionInfo(conn opensips_mi.Client, ch chan
 	m := versionRegexp.FindStringSubmatch(resp.ChildValues["Server"])
 	if m != nil {
 		ch <- prometheus.MustNewConstMetric(ose.versionInfo, prometheus.GaugeValue, 1, m[1:]...)
+
+		ose.mu.Lock()
+		ose.version = parseVersion(m[2])
+		ose.mu.Unlock()
 	}
 	return nil
 }
 
+var versionNumberRegexp = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// Parse an OpenSIPS version like "3.1.2" as major*100+minor, or 0 if unknown.
+func parseVersion(version string) int {
+	m := versionNumberRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return 0
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major*100 + minor
+}
+
 func (ose *opensipsExporter) fetchCommands(conn opensips_mi.Client) {
 	resp, err := conn.Command("which")
 	if err != nil {
@@ -136,37 +331,29 @@ func (ose *opensipsExporter) fetchCommands(conn opensips_mi.Client) {
 	ose.mu.Unlock()
 }
 
-func (ose *opensipsExporter) collectProcessInfo(conn opensips_mi.Client, ch chan<- prometheus.Metric, update bool) {
-	var processes [][]string
-
-	if update {
-		resp, err := conn.Command("ps")
-		if err != nil {
-			return
-		}
-		processes = make([][]string, 0, len(resp.Children))
-		for _, node := range resp.Children {
-			processes = append(processes, []string{node.Attrs["ID"], strings.TrimSpace(node.Attrs["Type"])})
-		}
-
-		ose.mu.Lock()
-		ose.processes = processes
-		ose.mu.Unlock()
+func (ose *opensipsExporter) collectStats(conn opensips_mi.Client, ch chan<- prometheus.Metric) (uptime float64) {
+	resp, err := conn.Command("get_statistics", "all")
+	if err != nil {
+		return
 	}
 
 	ose.mu.RLock()
-	defer ose.mu.RUnlock()
+	version := ose.version
+	ose.mu.RUnlock()
 
-	for _, proc := range ose.processes {
-		ch <- prometheus.MustNewConstMetric(ose.processInfo, prometheus.GaugeValue, 1, proc...)
+	// Processes by id, for labeling per-process stats with their type
+	var processes map[string]process
+	var aggregates roleAggregates
+	if ose.joinProcesses {
+		ose.mu.RLock()
+		processes = make(map[string]process, len(ose.processes))
+		for _, proc := range ose.processes {
+			processes[proc.id] = proc
+		}
+		ose.mu.RUnlock()
+		aggregates = make(roleAggregates)
 	}
-}
 
-func (ose *opensipsExporter) collectStats(conn opensips_mi.Client, ch chan<- prometheus.Metric) (uptime float64) {
-	resp, err := conn.Command("get_statistics", "all")
-	if err != nil {
-		return
-	}
 	for statName, statValue := range resp.ChildValues {
 		parts := strings.SplitN(statName, ":", 2)
 		if len(parts) != 2 {
@@ -174,6 +361,12 @@ func (ose *opensipsExporter) collectStats(conn opensips_mi.Client, ch chan<- pro
 		}
 		subsys := parts[0]
 		metric := strings.Replace(parts[1], " ", "_", -1)
+
+		// Shared memory groups report their stats as "shmem_group_<group>:<stat>"
+		if strings.HasPrefix(subsys, shmemGroupPrefix) {
+			metric = subsys[len(shmemGroupPrefix):] + ":" + metric
+			subsys = "shmem_group"
+		}
 		value, err := strconv.ParseFloat(statValue, 64)
 		if err != nil {
 			continue
@@ -188,10 +381,21 @@ func (ose *opensipsExporter) collectStats(conn opensips_mi.Client, ch chan<- pro
 			continue
 		}
 
-		for _, stat := range stats {
+		for i := range stats {
+			stat := &stats[i]
+			if !stat.supports(version) {
+				continue
+			}
 			if stat.regexp != nil {
 				mm := stat.regexp.FindStringSubmatch(metric)
 				if mm != nil {
+					if processes != nil && stat.joinedDesc != nil {
+						proc := processes[mm[stat.processLabel+1]]
+						ch <- prometheus.MustNewConstMetric(stat.joinedDesc, stat.value, value,
+							append(mm[1:], proc.typ, proc.role)...)
+						aggregates.add(stat, proc.role, value)
+						break
+					}
 					ch <- prometheus.MustNewConstMetric(stat.desc, stat.value, value, mm[1:]...)
 					break
 				}
@@ -202,80 +406,41 @@ func (ose *opensipsExporter) collectStats(conn opensips_mi.Client, ch chan<- pro
 		}
 	}
 
-	return
-}
-
-var profileValuesRegexp = regexp.MustCompile(`(?:^|,)([a-z0-9_]+)=([^,]*)`)
-
-func (ose *opensipsExporter) collectDialogProfiles(conn opensips_mi.Client, ch chan<- prometheus.Metric, update bool) {
-	var profiles map[string]bool
-
-	if update {
-		resp, err := conn.Command("list_all_profiles")
-		if err != nil {
-			return
-		}
-
-		profiles = make(map[string]bool, len(resp.ChildValues))
-		for profile, hasValues := range resp.ChildValues {
-			profiles[profile] = hasValues != "0"
-		}
-		ose.mu.Lock()
-		ose.profiles = profiles
-		ose.mu.Unlock()
+	for key, agg := range aggregates {
+		ch <- prometheus.MustNewConstMetric(key.stat.roleDesc, prometheus.GaugeValue, agg.value(key.stat), key.role)
 	}
 
-	ose.mu.RLock()
-	defer ose.mu.RUnlock()
-
-	for profile, hasValues := range ose.profiles {
-		if !hasValues {
-			continue
-		}
-
-		getResp, err := conn.Command("profile_get_values", profile)
-		if err != nil {
-			continue
-		}
-
-		for _, node := range getResp.Children {
-			count, err := strconv.ParseFloat(node.Attrs["count"], 64)
-			if err != nil {
-				continue
-			}
+	return
+}
 
-			// Parse dialog value as "name=value," pairs and export the pairs as labels
-			matches := profileValuesRegexp.FindAllStringSubmatch(node.Value, -1)
-			if matches != nil {
-				labelNames := []string{"profile"}
-				labels := []string{profile}
-				for _, match := range matches {
-					labelNames = append(labelNames, match[1])
-					labels = append(labels, match[2])
-				}
-				ch <- prometheus.MustNewConstMetric(
-					prometheus.NewDesc(
-						prometheus.BuildFQName(namespace, "dialog", "profiles_with_values_count"),
-						"Dialog profiles with counts",
-						labelNames,
-						nil,
-					),
-					prometheus.GaugeValue,
-					count,
-					labels...,
-				)
-			} else {
-				// Export just the profile and value labels
-				ch <- prometheus.MustNewConstMetric(ose.profilesValuesInfo, prometheus.GaugeValue, count,
-					profile, node.Value)
-			}
-		}
+func newOpensipsExporter(url string, opts exporterOptions) *opensipsExporter {
+	profiles := opts.profiles
+	if profiles == nil {
+		profiles = &profilesConfig{}
 	}
-}
 
-func newOpensipsExporter(url string) *opensipsExporter {
 	return &opensipsExporter{
-		url: url,
+		url:              url,
+		cacheTTL:         opts.cacheTTL,
+		profilesConfig:   profiles,
+		profileTotalSize: opts.profileTotalSize,
+		procfs:           opts.procfs,
+		joinProcesses:    opts.joinProcesses,
+		collectors:       opts.collectors,
+		cachedAt:         time.Now(),
+
+		scrapesCoalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "scrapes_coalesced_total",
+			Help:      "Total number of scrapes served by joining a scrape already in progress",
+		}),
+		restarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "target_restarts_total",
+			Help:      "Total number of OpenSIPS restarts detected by the exporter",
+		}),
 
 		up: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "up"),
@@ -283,6 +448,12 @@ func newOpensipsExporter(url string) *opensipsExporter {
 			nil,
 			nil,
 		),
+		startTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "start_time_seconds"),
+			"Start time of OpenSIPS since unix epoch in seconds",
+			nil,
+			nil,
+		),
 		versionInfo: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "version_info"),
 			"Version information (always 1)",
@@ -292,7 +463,25 @@ func newOpensipsExporter(url string) *opensipsExporter {
 		processInfo: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "process_info"),
 			"Process information (always 1)",
-			[]string{"id", "type"},
+			[]string{"id", "pid", "type", "role", "proto", "socket"},
+			nil,
+		),
+		processCPU: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "process", "cpu_seconds_total"),
+			"Total user and system CPU time spent by OpenSIPS process #id in seconds",
+			[]string{"id"},
+			nil,
+		),
+		processMemory: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "process", "resident_memory_bytes"),
+			"Resident memory size of OpenSIPS process #id in bytes",
+			[]string{"id"},
+			nil,
+		),
+		processFDs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "process", "open_fds"),
+			"Number of open file descriptors of OpenSIPS process #id",
+			[]string{"id"},
 			nil,
 		),
 		profilesValuesInfo: prometheus.NewDesc(
@@ -301,6 +490,24 @@ func newOpensipsExporter(url string) *opensipsExporter {
 			[]string{"profile", "value"},
 			nil,
 		),
+		profileSize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", "profile_size"),
+			"Number of dialogs in a dialog profile",
+			[]string{"profile"},
+			nil,
+		),
+		collectorSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "collector_success"),
+			"1 if the optional collector succeeded",
+			[]string{"collector"},
+			nil,
+		),
+		collectorDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "collector_duration_seconds"),
+			"Duration of the optional collector in seconds",
+			[]string{"collector"},
+			nil,
+		),
 	}
 }
 
@@ -309,13 +516,82 @@ var (
 		"The HTTP address to connect to OpenSIPS mi_json")
 	listenAddr = flag.String("web.listen-address", ":9441",
 		"The address to listen on for HTTP requests.")
+	cacheTTL = flag.Duration("opensips.cache-ttl", 10*time.Minute,
+		"How long to cache the OpenSIPS commands, processes and dialog profiles. 0 caches until OpenSIPS restarts.")
+	profilesConfigFile = flag.String("dialog.profiles-config", "",
+		"JSON file describing how dialog profile values are exported.")
+	profileValuesLimit = flag.Int("dialog.profile-values-limit", 100,
+		"Maximum number of values to export per dialog profile. 0 disables the limit.")
+	profileTotalSize = flag.Bool("dialog.profile-total-size", false,
+		"Also export the total size of dialog profiles with values.")
+	processStats = flag.Bool("process.stats", false,
+		"Export CPU, memory and file descriptor usage of the OpenSIPS processes, read from procfs. Requires running on the OpenSIPS host.")
+	procfsPath = flag.String("path.procfs", procfs.DefaultMountPoint,
+		"The procfs mount point.")
+	joinProcesses = flag.Bool("process.join-stats", false,
+		"Label per-process private memory and load stats with the process type and role, and aggregate them by role.")
+	maxRequests = flag.Int("web.max-requests", 10,
+		"Maximum number of concurrent scrape requests. 0 disables the limit.")
 )
 
+var scrapesRejected = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "exporter",
+	Name:      "scrapes_rejected_total",
+	Help:      "Total number of scrapes rejected because too many were in progress",
+})
+
+// Wrap a handler to serve at most max concurrent requests, rejecting the
+// excess with 503 Service Unavailable.
+func limitRequests(handler http.Handler, max int) http.Handler {
+	if max <= 0 {
+		return handler
+	}
+	sem := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			handler.ServeHTTP(w, r)
+		default:
+			scrapesRejected.Inc()
+			http.Error(w, "Too many concurrent scrapes", http.StatusServiceUnavailable)
+		}
+	})
+}
+
 func main() {
 	flag.Parse()
 
-	prometheus.MustRegister(newOpensipsExporter(*url))
+	profiles, err := loadProfilesConfig(*profilesConfigFile, *profileValuesLimit)
+	if err != nil {
+		log.Fatal("error loading dialog profiles configuration: ", err)
+	}
+
+	enabled, err := enabledCollectors()
+	if err != nil {
+		log.Fatal("error creating collectors: ", err)
+	}
+
+	var fs *procfs.FS
+	if *processStats {
+		procFS, err := procfs.NewFS(*procfsPath)
+		if err != nil {
+			log.Fatal("error opening procfs: ", err)
+		}
+		fs = &procFS
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
+	prometheus.MustRegister(newOpensipsExporter(*url, exporterOptions{
+		cacheTTL:         *cacheTTL,
+		profiles:         profiles,
+		profileTotalSize: *profileTotalSize,
+		procfs:           fs,
+		joinProcesses:    *joinProcesses,
+		collectors:       enabled,
+	}))
+	prometheus.MustRegister(scrapesRejected)
+
+	http.Handle("/metrics", limitRequests(promhttp.Handler(), *maxRequests))
 	log.Fatal(http.ListenAndServe(*listenAddr, nil))
 }
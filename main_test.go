@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectRestarts(t *testing.T) {
+	type scrape struct {
+		uptime float64
+		// How long the request for the uptime took
+		took       time.Duration
+		pidChanged bool
+	}
+	tests := []struct {
+		name    string
+		scrapes []scrape
+		want    float64
+	}{
+		{
+			name:    "running",
+			scrapes: []scrape{{100, 0, false}, {100, 0, false}, {100.5, 0, false}},
+			want:    0,
+		},
+		{
+			name:    "slow request",
+			scrapes: []scrape{{100, 0, false}, {100, 5 * time.Second, false}, {100, 0, false}},
+			want:    0,
+		},
+		{
+			name:    "restarted",
+			scrapes: []scrape{{100, 0, false}, {10, 0, false}, {10, 0, false}},
+			want:    1,
+		},
+		{
+			name:    "restarted during a slow request",
+			scrapes: []scrape{{100, 0, false}, {10, 5 * time.Second, false}},
+			want:    1,
+		},
+		{
+			name:    "main process replaced",
+			scrapes: []scrape{{100, 0, false}, {100, 0, true}},
+			want:    1,
+		},
+		{
+			name:    "no uptime",
+			scrapes: []scrape{{0, 0, false}, {0, 0, false}},
+			want:    0,
+		},
+	}
+
+	for _, test := range tests {
+		ose := newOpensipsExporter("", exporterOptions{})
+		ch := make(chan prometheus.Metric, 1)
+		for _, s := range test.scrapes {
+			ose.collectRestarts(s.uptime, time.Now().Add(-s.took), s.pidChanged, ch)
+			if s.uptime > 0 {
+				<-ch
+			}
+		}
+
+		var pb dto.Metric
+		if err := ose.restarts.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if got := pb.Counter.GetValue(); got != test.want {
+			t.Errorf("%s: %v restarts, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RTPProxy or RTPEngine nodes collector, based on rtpproxy_show or rtpengine_show
+type mediaRelayCollector struct {
+	command string
+
+	enabled      *prometheus.Desc
+	recheckTicks *prometheus.Desc
+	weight       *prometheus.Desc
+	load         *prometheus.Desc
+}
+
+func init() {
+	registerCollector("rtpproxy", "Export the state of the RTPProxy nodes, listed by rtpproxy_show.",
+		[]string{"rtpproxy_show"}, func() (collector, error) {
+			return newMediaRelayCollector("rtpproxy", "RTPProxy", "rtpproxy_show"), nil
+		})
+	registerCollector("rtpengine", "Export the state of the RTPEngine nodes, listed by rtpengine_show.",
+		[]string{"rtpengine_show"}, func() (collector, error) {
+			return newMediaRelayCollector("rtpengine", "RTPEngine", "rtpengine_show"), nil
+		})
+}
+
+func newMediaRelayCollector(subsystem string, name string, command string) collector {
+	return &mediaRelayCollector{
+		command: command,
+
+		enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "node_enabled"),
+			"1 if OpenSIPS considers the "+name+" node enabled",
+			[]string{"set", "node"},
+			nil,
+		),
+		recheckTicks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "node_recheck_ticks"),
+			"Number of ticks until OpenSIPS checks the disabled "+name+" node again",
+			[]string{"set", "node"},
+			nil,
+		),
+		weight: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "node_weight"),
+			"Weight of the "+name+" node",
+			[]string{"set", "node"},
+			nil,
+		),
+		load: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "node_load"),
+			"Load reported by the "+name+" node",
+			[]string{"set", "node"},
+			nil,
+		),
+	}
+}
+
+func (c *mediaRelayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.enabled
+	ch <- c.recheckTicks
+	ch <- c.weight
+	ch <- c.load
+}
+
+func (c *mediaRelayCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command(c.command)
+	if err != nil {
+		return err
+	}
+
+	for _, setNode := range childList(resp, "Set", "Sets") {
+		set := nodeValue(setNode, "id")
+
+		for _, node := range childList(setNode, "node", "Nodes") {
+			name := nodeValue(node, "node")
+
+			if disabled, err := strconv.ParseFloat(node.Get("disabled"), 64); err == nil {
+				enabled := 0.0
+				if disabled == 0 {
+					enabled = 1
+				}
+				ch <- prometheus.MustNewConstMetric(c.enabled, prometheus.GaugeValue, enabled, set, name)
+			}
+			if ticks, err := strconv.ParseFloat(node.Get("recheck_ticks"), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(c.recheckTicks, prometheus.GaugeValue, ticks, set, name)
+			}
+			if weight, err := strconv.ParseFloat(node.Get("weight"), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(c.weight, prometheus.GaugeValue, weight, set, name)
+			}
+			if load, err := strconv.ParseFloat(node.Get("load"), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, load, set, name)
+			}
+		}
+	}
+
+	return nil
+}
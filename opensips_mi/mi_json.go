@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -39,8 +40,8 @@ func NewMIJsonClient(miJsonUrl string, config MIJsonConfig) (Client, error) {
 	}, nil
 }
 
-// Execute an OpenSIPS MI commnad and return the resulting tree of MI nodes.
-func (mj *miJsonClient) Command(cmd string, args ... string) (*MINode, error) {
+// Send an OpenSIPS MI command over HTTP and return the response.
+func (mj *miJsonClient) get(cmd string, args ...string) (*http.Response, error) {
 	reqUrl := mj.url + "/" + cmd
 	if len(args) > 0 {
 		query := url.Values{}
@@ -53,29 +54,50 @@ func (mj *miJsonClient) Command(cmd string, args ... string) (*MINode, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("mi_json status: %s", resp.StatusCode)
+		resp.Body.Close()
+		return nil, fmt.Errorf("mi_json status: %d", resp.StatusCode)
 	}
 
+	return resp, nil
+}
+
+// Execute an OpenSIPS MI commnad and return the resulting tree of MI nodes.
+func (mj *miJsonClient) Command(cmd string, args ... string) (*MINode, error) {
+	resp, err := mj.get(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
 	// Decode the response JSON
-	body := map[string]interface{}{}
+	var body interface{}
 	dec := json.NewDecoder(resp.Body)
 	if err = dec.Decode(&body); err != nil {
 		return nil, err
 	}
 
+	// Newer versions may return a list at the top level
+	node := &MINode{}
+	if lst, ok := body.([]interface{}); ok {
+		if err = node.fromJsonList(lst); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
 	// Handle errors
-	if v, ok := body["error"]; ok {
-		if v := v.(map[string]interface{}); ok {
-			return nil, fmt.Errorf("mi_json error: %s", v["message"])
+	if mp, ok := body.(map[string]interface{}); ok {
+		if v, ok := mp["error"]; ok {
+			if v, ok := v.(map[string]interface{}); ok {
+				return nil, fmt.Errorf("mi_json error: %s", v["message"])
+			}
+			return nil, fmt.Errorf("mi_json error")
 		}
-		return nil, fmt.Errorf("mi_json error")
 	}
 
 	// Parse the MI node tree
-	node := &MINode{}
 	if err = node.fromJson(body); err != nil {
 		return nil, err
 	}
@@ -83,72 +105,148 @@ func (mj *miJsonClient) Command(cmd string, args ... string) (*MINode, error) {
 	return node, nil
 }
 
+// Execute an OpenSIPS MI command and call fn with every node of the first
+// list in the response, without decoding the whole response at once.
+func (mj *miJsonClient) CommandStream(fn func(*MINode) error, cmd string, args ...string) error {
+	resp, err := mj.get(cmd, args...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = streamJson(json.NewDecoder(resp.Body), fn)
+	return err
+}
+
+// Decode the next JSON value, calling fn with every element of the first
+// list found in it. Returns whether a list was found.
+func streamJson(dec *json.Decoder, fn func(*MINode) error) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+
+	switch tok {
+	case json.Delim('['):
+		for dec.More() {
+			var elem interface{}
+			if err = dec.Decode(&elem); err != nil {
+				return true, err
+			}
+			node := &MINode{}
+			if err = node.fromJsonValue(elem); err != nil {
+				return true, err
+			}
+			if err = fn(node); err != nil {
+				return true, err
+			}
+		}
+		_, err = dec.Token()
+		return true, err
+
+	case json.Delim('{'):
+		found := false
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return found, err
+			}
+
+			// Handle errors
+			if key == "error" {
+				var v interface{}
+				if err = dec.Decode(&v); err != nil {
+					return found, err
+				}
+				if v, ok := v.(map[string]interface{}); ok {
+					return found, fmt.Errorf("mi_json error: %s", v["message"])
+				}
+				return found, fmt.Errorf("mi_json error")
+			}
+
+			if found {
+				var skip json.RawMessage
+				if err = dec.Decode(&skip); err != nil {
+					return found, err
+				}
+				continue
+			}
+			if found, err = streamJson(dec, fn); err != nil {
+				return found, err
+			}
+		}
+		_, err = dec.Token()
+		return found, err
+	}
+
+	return false, nil
+}
+
 func (mj *miJsonClient) Close() error {
 	return nil
 }
 
+// Keys of the JSON representation of an MI node
+var miNodeKeys = map[string]bool{
+	"name":       true,
+	"value":      true,
+	"attributes": true,
+	"children":   true,
+}
+
 // Convert the OpenSIPS JSON mi_tree representation to a tree of MINodes.
 func (n *MINode) fromJson(value interface{}) error {
 	switch value.(type) {
 	case map[string]interface{}:
 		mapval := value.(map[string]interface{})
 
+		// Objects with other keys are plain maps, as returned by newer versions
 		isNode := false
-		if val, exists := mapval["name"]; exists {
-			if s, ok := val.(string); ok {
-				n.Name = s
-				isNode = true
+		plain := false
+		for k := range mapval {
+			if !miNodeKeys[k] {
+				plain = true
 			}
 		}
-		if val, exists := mapval["value"]; exists {
-			if s, ok := val.(string); ok {
-				n.Value = s
-				isNode = true
-			}
-		}
-		if val, exists := mapval["attributes"]; exists {
-			if m, ok := val.(map[string]interface{}); ok {
-				n.Attrs = map[string]string{}
-				for k, elem := range m {
-					if vs, ok := elem.(string); ok {
-						n.Attrs[k] = vs
-					}
+
+		if !plain {
+			if val, exists := mapval["name"]; exists {
+				if s, ok := val.(string); ok {
+					n.Name = s
+					isNode = true
 				}
-				isNode = true
 			}
-		}
-		if val, exists := mapval["children"]; exists {
-			if lst, ok := val.([]interface{}); ok {
-				if err := n.fromJsonList(lst); err != nil {
-					return err
+			if val, exists := mapval["value"]; exists {
+				if s, ok := val.(string); ok {
+					n.Value = s
+					isNode = true
 				}
-				isNode = true
 			}
-			if mp, ok := val.(map[string]interface{}); ok {
-				// parse as map
-				n.Children = make([]*MINode, 0, len(mp))
-				n.ChildValues = make(map[string]string, len(mapval))
-				for k, v := range mp {
-					child := &MINode{Name: k}
-					if sv, ok := v.(string); ok {
-						child.Value = sv
-					} else if _, ok := v.(map[string]interface{}); ok {
-						if err := child.fromJson(v); err != nil {
-							return err
-						}
-					} else if lst, ok := v.([]interface{}); ok {
-						if err := child.fromJsonList(lst); err != nil {
-							return err
+			if val, exists := mapval["attributes"]; exists {
+				if m, ok := val.(map[string]interface{}); ok {
+					n.Attrs = map[string]string{}
+					for k, elem := range m {
+						if vs, ok := elem.(string); ok {
+							n.Attrs[k] = vs
 						}
-					} else {
-						return fmt.Errorf("Unsupported type in JSON: %+v", reflect.TypeOf(v))
 					}
-					n.Children = append(n.Children, child)
-					if child.Name != "" {
-						n.ChildValues[child.Name] = child.Value
+					isNode = true
+				}
+			}
+			if val, exists := mapval["children"]; exists {
+				if lst, ok := val.([]interface{}); ok {
+					if err := n.fromJsonList(lst); err != nil {
+						return err
+					}
+					isNode = true
+				}
+				if mp, ok := val.(map[string]interface{}); ok {
+					// parse as map
+					if err := n.fromJsonMap(mp); err != nil {
+						return err
 					}
+					isNode = true
 				}
-				isNode = true
 			}
 		}
 
@@ -167,13 +265,8 @@ func (n *MINode) fromJson(value interface{}) error {
 			}
 
 			// parse as map
-			n.Children = make([]*MINode, 0, len(mapval))
-			n.ChildValues = make(map[string]string, len(mapval))
-			for k, v := range mapval {
-				if vs, ok := v.(string); ok {
-					n.Children = append(n.Children, &MINode{Name: k, Value: vs})
-					n.ChildValues[k] = vs
-				}
+			if err := n.fromJsonMap(mapval); err != nil {
+				return err
 			}
 		}
 
@@ -184,11 +277,28 @@ func (n *MINode) fromJson(value interface{}) error {
 	return nil
 }
 
+// Convert a JSON object to children MINodes named after its keys.
+func (n *MINode) fromJsonMap(mp map[string]interface{}) error {
+	n.Children = make([]*MINode, 0, len(mp))
+	n.ChildValues = make(map[string]string, len(mp))
+	for k, v := range mp {
+		child := &MINode{}
+		if err := child.fromJsonValue(v); err != nil {
+			return err
+		}
+		child.Name = k
+		n.Children = append(n.Children, child)
+		n.ChildValues[child.Name] = child.Value
+	}
+	return nil
+}
+
 func (n *MINode) fromJsonList(lst []interface{}) error {
 	n.Children = make([]*MINode, 0, len(lst))
+	n.ChildValues = make(map[string]string, len(lst))
 	for _, elem := range lst {
 		child := &MINode{}
-		if err := child.fromJson(elem); err != nil {
+		if err := child.fromJsonValue(elem); err != nil {
 			return err
 		}
 		n.Children = append(n.Children, child)
@@ -198,3 +308,21 @@ func (n *MINode) fromJsonList(lst []interface{}) error {
 	}
 	return nil
 }
+
+// Convert any JSON value to an MINode, scalars becoming the node value.
+func (n *MINode) fromJsonValue(value interface{}) error {
+	switch value.(type) {
+	case string:
+		n.Value = value.(string)
+	case float64:
+		n.Value = strconv.FormatFloat(value.(float64), 'f', -1, 64)
+	case bool:
+		n.Value = strconv.FormatBool(value.(bool))
+	case nil:
+	case []interface{}:
+		return n.fromJsonList(value.([]interface{}))
+	default:
+		return n.fromJson(value)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package opensips_mi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Render a tree of MINodes, sorting the children as JSON objects have no order.
+func dumpNode(n *MINode) string {
+	s := n.Name
+	if n.Value != "" {
+		s += "=" + n.Value
+	}
+	if len(n.Attrs) > 0 {
+		attrs := make([]string, 0, len(n.Attrs))
+		for k, v := range n.Attrs {
+			attrs = append(attrs, k+"="+v)
+		}
+		sort.Strings(attrs)
+		s += "{" + strings.Join(attrs, ",") + "}"
+	}
+	if len(n.Children) > 0 {
+		children := make([]string, 0, len(n.Children))
+		for _, child := range n.Children {
+			children = append(children, dumpNode(child))
+		}
+		sort.Strings(children)
+		s += "[" + strings.Join(children, " ") + "]"
+	}
+	return s
+}
+
+func parseJson(t *testing.T, data string) *MINode {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		t.Fatal(err)
+	}
+	node := &MINode{}
+	if err := node.fromJson(body); err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+var fromJsonTests = []struct {
+	name string
+	json string
+	want string
+}{
+	{
+		name: "2.x statistics",
+		json: `{"core:uptime": "120", "shmem:used_size": "1024"}`,
+		want: `[core:uptime=120 shmem:used_size=1024]`,
+	},
+	{
+		name: "2.x node list",
+		json: `{"Process": [
+			{"value": "0", "attributes": {"ID": "0", "PID": "100", "Type": "attendant"}},
+			{"value": "1", "attributes": {"ID": "1", "PID": "101", "Type": "timer"}}
+		]}`,
+		want: `Process[=0{ID=0,PID=100,Type=attendant} =1{ID=1,PID=101,Type=timer}]`,
+	},
+	{
+		name: "2.x node with children map",
+		json: `{"name": "Profile", "value": "calls", "children": {
+			"count": "3",
+			"value": {"value": "alice", "attributes": {"has_value": "yes"}}
+		}}`,
+		want: `Profile=calls[count=3 value=alice{has_value=yes}]`,
+	},
+	{
+		name: "numbers, booleans and null",
+		json: `{"uptime": 120, "ratio": 0.5, "enabled": true, "none": null}`,
+		want: `[enabled=true none ratio=0.5 uptime=120]`,
+	},
+	{
+		name: "nested objects",
+		json: `{"Dialog": {"hash": "1:2", "state": 4}}`,
+		want: `[Dialog[hash=1:2 state=4]]`,
+	},
+	{
+		name: "3.x plain objects",
+		json: `{"Domains": [{"name": "default", "type": "server", "certificate": "/etc/opensips/cert.pem"}]}`,
+		want: `Domains[[certificate=/etc/opensips/cert.pem name=default type=server]]`,
+	},
+	{
+		name: "objects with node keys only are MI nodes",
+		json: `{"Domains": [{"name": "default", "value": "1"}]}`,
+		want: `Domains[default=1]`,
+	},
+	{
+		name: "single-key list",
+		json: `{"AORs": [{"AOR": "alice", "Contacts": [{"Contact": "sip:alice@10.0.0.1", "Expires": 3600}]}]}`,
+		want: `AORs[[AOR=alice Contacts[[Contact=sip:alice@10.0.0.1 Expires=3600]]]]`,
+	},
+	{
+		name: "single-key list nested in an object keeps its key",
+		json: `{"Resources": {"Resource": [{"name": "pstn", "value": "10"}]}, "Count": "1"}`,
+		want: `[Count=1 Resources[pstn=10]]`,
+	},
+	{
+		name: "MI node nested in an object keeps its key",
+		json: `{"children": {"Set": {"name": "1", "value": "active"}}}`,
+		want: `[Set=active]`,
+	},
+}
+
+func TestFromJson(t *testing.T) {
+	for _, test := range fromJsonTests {
+		if got := dumpNode(parseJson(t, test.json)); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	node := parseJson(t, `{"value": "x", "attributes": {"a": "1"}, "children": [{"name": "b", "value": "2"}]}`)
+	for name, want := range map[string]string{"a": "1", "b": "2", "c": ""} {
+		if got := node.Get(name); got != want {
+			t.Errorf("Get(%q): got %q, want %q", name, got, want)
+		}
+	}
+}
+
+var commandTests = []struct {
+	name    string
+	json    string
+	want    string
+	wantErr bool
+}{
+	{
+		name: "object",
+		json: `{"Sets": [{"id": "1"}]}`,
+		want: `Sets[[id=1]]`,
+	},
+	{
+		name: "top level list",
+		json: `[{"Group": "core", "Readiness": true}]`,
+		want: `[[Group=core Readiness=true]]`,
+	},
+	{
+		name:    "error",
+		json:    `{"error": {"code": 500, "message": "Internal error"}}`,
+		wantErr: true,
+	},
+}
+
+func TestCommand(t *testing.T) {
+	for _, test := range commandTests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, test.json)
+		}))
+		client, err := NewMIJsonClient(srv.URL, MIJsonConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		node, err := client.Command("test")
+		srv.Close()
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := dumpNode(node); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+var commandStreamTests = []struct {
+	name    string
+	json    string
+	want    []string
+	wantErr bool
+}{
+	{
+		name: "list",
+		json: `{"Dialogs": [{"ID": "1"}, {"ID": "2"}]}`,
+		want: []string{`[ID=1]`, `[ID=2]`},
+	},
+	{
+		name: "top level list",
+		json: `[{"ID": "1"}]`,
+		want: []string{`[ID=1]`},
+	},
+	{
+		name: "first list in document order",
+		json: `{"Count": 1, "Dialogs": [{"ID": "1"}], "Other": [{"ID": "2"}]}`,
+		want: []string{`[ID=1]`},
+	},
+	{
+		name: "nested list",
+		json: `{"Domain": {"name": "location", "AORs": [{"AOR": "alice"}]}}`,
+		want: []string{`[AOR=alice]`},
+	},
+	{
+		name:    "error",
+		json:    `{"error": {"code": 500, "message": "Internal error"}}`,
+		wantErr: true,
+	},
+}
+
+func TestCommandStream(t *testing.T) {
+	for _, test := range commandStreamTests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, test.json)
+		}))
+		client, err := NewMIJsonClient(srv.URL, MIJsonConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		err = client.(StreamingClient).CommandStream(func(node *MINode) error {
+			got = append(got, dumpNode(node))
+			return nil
+		}, "test")
+		srv.Close()
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -14,3 +14,28 @@ type Client interface {
 	Command(cmd string, args ... string) (*MINode, error)
 	Close() error
 }
+
+// OpenSIPS MI Client able to process large responses node by node
+type StreamingClient interface {
+	Client
+	CommandStream(fn func(*MINode) error, cmd string, args ... string) error
+}
+
+// Return the value of the named attribute or child of the node, since
+// different OpenSIPS versions report the same field either way.
+func (n *MINode) Get(name string) string {
+	if v, ok := n.Attrs[name]; ok {
+		return v
+	}
+	return n.ChildValues[name]
+}
+
+// Return the first child of the node with the given name, or nil.
+func (n *MINode) Child(name string) *MINode {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
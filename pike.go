@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pikeTopIPs = flag.Int("collector.pike.top-ips", 0,
+	"Number of blocked IPs with the most hits to export by address. 0 exports none.")
+
+// Pike blocked IPs collector, based on pike_list or pike_top
+type pikeCollector struct {
+	blocked      map[string]bool
+	newlyBlocked float64
+
+	blockedDesc      *prometheus.Desc
+	hitsDesc         *prometheus.Desc
+	newlyBlockedDesc *prometheus.Desc
+}
+
+func init() {
+	registerCollector("pike", "Export the IPs blocked by pike, listed by pike_list or pike_top.",
+		[]string{"pike_list", "pike_top"}, newPikeCollector)
+}
+
+func newPikeCollector() (collector, error) {
+	return &pikeCollector{
+		blockedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pike", "blocked_ips"),
+			"Number of IPs currently blocked by pike",
+			nil,
+			nil,
+		),
+		hitsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pike", "blocked_ip_hits"),
+			"Number of recent hits of the blocked IPs with the most hits",
+			[]string{"ip"},
+			nil,
+		),
+		newlyBlockedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pike", "newly_blocked_ips_total"),
+			"Total number of IPs found blocked since the previous scrape",
+			nil,
+			nil,
+		),
+	}, nil
+}
+
+func (c *pikeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.blockedDesc
+	ch <- c.hitsDesc
+	ch <- c.newlyBlockedDesc
+}
+
+func (c *pikeCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	cmd := t.command("pike_list", "pike_top")
+	var args []string
+	if cmd == "pike_top" {
+		// Older versions list all the tracked IPs, only the hot ones are blocked
+		args = []string{"HOT"}
+	}
+
+	resp, err := t.conn.Command(cmd, args...)
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[string]bool)
+	hits := newLabelCounts(1)
+	for _, node := range childList(resp, "IP", "IPs") {
+		// pike_top reports the IPs as HOT or WARM, only the HOT ones are
+		// blocked, while pike_list has no status and lists blocked IPs only
+		if status := strings.ToLower(node.Get("status")); status != "" && status != "hot" {
+			continue
+		}
+
+		ip := nodeValue(node, "ip")
+		if ip == "" || blocked[ip] {
+			continue
+		}
+		blocked[ip] = true
+
+		prev, _ := strconv.ParseFloat(node.Get("leaf_hits_prev"), 64)
+		curr, _ := strconv.ParseFloat(node.Get("leaf_hits_curr"), 64)
+		hits.add(prev+curr, ip)
+	}
+
+	if c.blocked != nil {
+		for ip := range blocked {
+			if !c.blocked[ip] {
+				c.newlyBlocked++
+			}
+		}
+	}
+	c.blocked = blocked
+
+	ch <- prometheus.MustNewConstMetric(c.blockedDesc, prometheus.GaugeValue, float64(len(blocked)))
+	ch <- prometheus.MustNewConstMetric(c.newlyBlockedDesc, prometheus.CounterValue, c.newlyBlocked)
+
+	if *pikeTopIPs > 0 {
+		for _, value := range hits.top(*pikeTopIPs) {
+			if value.labels[0] != otherLabelValue {
+				ch <- prometheus.MustNewConstMetric(c.hitsDesc, prometheus.GaugeValue, value.count, value.labels...)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OpenSIPS process, as listed by ps
+type process struct {
+	id     string
+	pid    string
+	typ    string
+	role   string
+	proto  string
+	socket string
+}
+
+// Matches process types like "SIP receiver udp:10.0.0.1:5060"
+var processTypeRegexp = regexp.MustCompile(`^(.*?)\s+([a-z_]+):(\S+)$`)
+
+// Parse the process type into its role and the protocol and socket it serves.
+func newProcess(id, pid, typ string) process {
+	proc := process{id: id, pid: pid, typ: typ, role: typ}
+	if m := processTypeRegexp.FindStringSubmatch(typ); m != nil {
+		proc.role, proc.proto, proc.socket = m[1], m[2], m[3]
+	}
+	return proc
+}
+
+// Export the OpenSIPS processes, returning whether the main process PID changed.
+func (ose *opensipsExporter) collectProcessInfo(conn opensips_mi.Client, ch chan<- prometheus.Metric) bool {
+	resp, err := conn.Command("ps")
+	if err != nil {
+		return false
+	}
+	processes := make([]process, 0, len(resp.Children))
+	for _, node := range resp.Children {
+		processes = append(processes, newProcess(node.Get("ID"), node.Get("PID"), strings.TrimSpace(node.Get("Type"))))
+	}
+
+	// The main process keeps its PID for the lifetime of OpenSIPS, while
+	// others may come and go, like the auto-scaled ones of newer versions
+	var mainPid string
+	if len(processes) > 0 {
+		mainPid = processes[0].pid
+	}
+
+	ose.mu.RLock()
+	changed := ose.processes != nil && !reflect.DeepEqual(processes, ose.processes)
+	pidChanged := ose.mainPid != "" && mainPid != ose.mainPid
+	ose.mu.RUnlock()
+
+	// A changed process list means OpenSIPS was restarted or reconfigured
+	if changed {
+		ose.invalidateCaches()
+	}
+
+	ose.mu.Lock()
+	ose.processes = processes
+	ose.mainPid = mainPid
+	ose.mu.Unlock()
+
+	for _, proc := range processes {
+		ch <- prometheus.MustNewConstMetric(ose.processInfo, prometheus.GaugeValue, 1,
+			proc.id, proc.pid, proc.typ, proc.role, proc.proto, proc.socket)
+	}
+
+	if ose.procfs != nil {
+		ose.collectProcessStats(processes, ch)
+	}
+
+	return pidChanged
+}
+
+// Export the resource usage of the OpenSIPS processes, joined on PID with procfs.
+func (ose *opensipsExporter) collectProcessStats(processes []process, ch chan<- prometheus.Metric) {
+	for _, proc := range processes {
+		pid, err := strconv.Atoi(proc.pid)
+		if err != nil {
+			continue
+		}
+		p, err := ose.procfs.NewProc(pid)
+		if err != nil {
+			continue
+		}
+
+		if stat, err := p.NewStat(); err == nil {
+			ch <- prometheus.MustNewConstMetric(ose.processCPU, prometheus.CounterValue, stat.CPUTime(), proc.id)
+			ch <- prometheus.MustNewConstMetric(ose.processMemory, prometheus.GaugeValue, float64(stat.ResidentMemory()), proc.id)
+		}
+		if fds, err := p.FileDescriptorsLen(); err == nil {
+			ch <- prometheus.MustNewConstMetric(ose.processFDs, prometheus.GaugeValue, float64(fds), proc.id)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How the values of a dialog profile are parsed into labels
+type profileConfig struct {
+	// Regular expression whose named groups become labels
+	Regexp string `json:"regexp"`
+	// Fixed set of labels parsed from "name=value," pairs
+	Labels []string `json:"labels"`
+	// Maximum number of values to export, the rest are aggregated as "other"
+	Limit int `json:"limit"`
+
+	regexp     *regexp.Regexp
+	labelNames []string
+	desc       *prometheus.Desc
+}
+
+// Dialog profiles configuration
+type profilesConfig struct {
+	// Default maximum number of values to export per profile
+	Limit int `json:"limit"`
+	// Per-profile configuration, keyed by profile name
+	Profiles map[string]*profileConfig `json:"profiles"`
+}
+
+// Load the dialog profiles configuration from a JSON file.
+func loadProfilesConfig(path string, limit int) (*profilesConfig, error) {
+	config := &profilesConfig{Limit: limit}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err = json.NewDecoder(f).Decode(config); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if err := config.compile(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (pc *profilesConfig) compile() error {
+	names := make(map[string]string, len(pc.Profiles))
+
+	for profile, config := range pc.Profiles {
+		if config == nil {
+			config = &profileConfig{}
+			pc.Profiles[profile] = config
+		}
+
+		var labelNames []string
+		if config.Regexp != "" {
+			re, err := regexp.Compile(config.Regexp)
+			if err != nil {
+				return fmt.Errorf("dialog profile %s: %s", profile, err)
+			}
+			config.regexp = re
+			for _, name := range re.SubexpNames()[1:] {
+				if name != "" {
+					labelNames = append(labelNames, name)
+				}
+			}
+		} else {
+			labelNames = config.Labels
+		}
+
+		if len(labelNames) == 0 {
+			// Values are exported as is, along with unconfigured profiles
+			continue
+		}
+
+		config.labelNames = []string{"profile"}
+		seen := map[string]bool{"profile": true}
+		for _, name := range labelNames {
+			name = sanitizeLabelName(name)
+			if seen[name] {
+				return fmt.Errorf("dialog profile %s: duplicate label %s", profile, name)
+			}
+			seen[name] = true
+			config.labelNames = append(config.labelNames, name)
+		}
+
+		name := "profile_" + sanitizeLabelName(profile) + "_count"
+		if other, exists := names[name]; exists {
+			return fmt.Errorf("dialog profiles %s and %s map to the same metric", other, profile)
+		}
+		names[name] = profile
+
+		config.desc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dialog", name),
+			"Dialog profile "+profile+" counts by value",
+			config.labelNames,
+			nil,
+		)
+	}
+
+	return nil
+}
+
+// Return the configuration of a dialog profile, or nil if it has none.
+func (pc *profilesConfig) profile(name string) *profileConfig {
+	if config, exists := pc.Profiles[name]; exists && config.desc != nil {
+		return config
+	}
+	return nil
+}
+
+// Return the maximum number of values to export for a dialog profile.
+func (pc *profilesConfig) limit(name string) int {
+	if config, exists := pc.Profiles[name]; exists && config.Limit != 0 {
+		return config.Limit
+	}
+	return pc.Limit
+}
+
+// Parse a dialog profile value into label values, excluding the profile label.
+func (pc *profileConfig) labelValues(value string) ([]string, bool) {
+	labels := make([]string, len(pc.labelNames)-1)
+
+	if pc.regexp != nil {
+		m := pc.regexp.FindStringSubmatch(value)
+		if m == nil {
+			return nil, false
+		}
+		i := 0
+		for j, name := range pc.regexp.SubexpNames()[1:] {
+			if name != "" {
+				labels[i] = m[j+1]
+				i++
+			}
+		}
+		return labels, true
+	}
+
+	// Parse dialog value as "name=value," pairs
+	matches := profileValuesRegexp.FindAllStringSubmatch(value, -1)
+	if matches == nil {
+		return nil, false
+	}
+	for _, match := range matches {
+		for i, name := range pc.labelNames[1:] {
+			if name == sanitizeLabelName(match[1]) {
+				labels[i] = match[2]
+			}
+		}
+	}
+	return labels, true
+}
+
+var invalidLabelCharsRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Turn an arbitrary string into a valid Prometheus label name.
+func sanitizeLabelName(name string) string {
+	name = invalidLabelCharsRegexp.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	// Names starting with __ are reserved for internal use
+	for strings.HasPrefix(name, "__") {
+		name = name[1:]
+	}
+	return name
+}
+
+var profileValuesRegexp = regexp.MustCompile(`(?:^|,)([a-z0-9_]+)=([^,]*)`)
+
+func (ose *opensipsExporter) collectDialogProfiles(conn opensips_mi.Client, ch chan<- prometheus.Metric, update bool) {
+	var profiles map[string]bool
+
+	if update {
+		resp, err := conn.Command("list_all_profiles")
+		if err != nil {
+			return
+		}
+
+		profiles = make(map[string]bool, len(resp.Children))
+		for _, node := range resp.Children {
+			profile, hasValues := node.Name, node.Value
+			if name := node.Get("name"); name != "" {
+				// Newer versions list profiles as objects
+				profile, hasValues = name, node.Get("has value")
+				if hasValues == "" {
+					hasValues = node.Get("has_value")
+				}
+			}
+			// Shared profiles may be listed with their /s or /b sharing tag
+			if i := strings.LastIndex(profile, "/"); i > 0 {
+				profile = profile[:i]
+			}
+			profiles[profile] = parseFlag(hasValues) == 1
+		}
+		ose.mu.Lock()
+		ose.profiles = profiles
+		ose.mu.Unlock()
+	}
+
+	ose.mu.RLock()
+	defer ose.mu.RUnlock()
+
+	for profile, hasValues := range ose.profiles {
+		if !hasValues || ose.profileTotalSize {
+			ose.collectDialogProfileSize(conn, ch, profile)
+		}
+		if !hasValues {
+			continue
+		}
+
+		getResp, err := conn.Command("profile_get_values", profile)
+		if err != nil {
+			continue
+		}
+
+		config := ose.profilesConfig.profile(profile)
+		width := 1
+		if config != nil {
+			width = len(config.labelNames) - 1
+		}
+
+		counts := newLabelCounts(width)
+		for _, node := range childList(getResp, "Values", "value") {
+			// Older versions report the count as an attribute of the value,
+			// newer ones list objects with value and count members
+			count, err := strconv.ParseFloat(node.Get("count"), 64)
+			if err != nil {
+				continue
+			}
+			profileValue := nodeValue(node, "value")
+
+			if config == nil {
+				counts.add(count, profileValue)
+				continue
+			}
+
+			labels, ok := config.labelValues(profileValue)
+			if !ok {
+				// Count values we cannot parse along with the values beyond the limit
+				counts.addOther(count)
+				continue
+			}
+			counts.add(count, labels...)
+		}
+
+		for _, value := range counts.top(ose.profilesConfig.limit(profile)) {
+			if config == nil {
+				// Export just the profile and value labels
+				ch <- prometheus.MustNewConstMetric(ose.profilesValuesInfo, prometheus.GaugeValue, value.count,
+					profile, value.labels[0])
+			} else {
+				ch <- prometheus.MustNewConstMetric(config.desc, prometheus.GaugeValue, value.count,
+					append([]string{profile}, value.labels...)...)
+			}
+		}
+	}
+}
+
+// Export the total number of dialogs in a dialog profile.
+func (ose *opensipsExporter) collectDialogProfileSize(conn opensips_mi.Client, ch chan<- prometheus.Metric, profile string) {
+	resp, err := conn.Command("profile_get_size", profile)
+	if err != nil {
+		return
+	}
+
+	count := resp.Get("count")
+	for _, node := range resp.Children {
+		if count != "" {
+			break
+		}
+		count = node.Get("count")
+	}
+
+	size, err := strconv.ParseFloat(count, 64)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(ose.profileSize, prometheus.GaugeValue, size, profile)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSanitizeLabelName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"caller", "caller"},
+		{"caller-domain", "caller_domain"},
+		{"caller.domain", "caller_domain"},
+		{"1st", "_1st"},
+		{"", "_"},
+		{"__name", "_name"},
+		{"___name", "_name"},
+		{"-name", "_name"},
+	}
+	for _, test := range tests {
+		if got := sanitizeLabelName(test.name); got != test.want {
+			t.Errorf("sanitizeLabelName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProfilesConfigCompile(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles map[string]*profileConfig
+		// Label names of each configured profile, or the expected error
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "regexp",
+			profiles: map[string]*profileConfig{
+				"caller": {Regexp: `^(?P<user>[^@]+)@(?P<domain>.+)$`},
+			},
+			want: map[string][]string{"caller": {"profile", "user", "domain"}},
+		},
+		{
+			name: "unnamed regexp groups are not labels",
+			profiles: map[string]*profileConfig{
+				"caller": {Regexp: `^([^@]+)@(?P<domain>.+)$`},
+			},
+			want: map[string][]string{"caller": {"profile", "domain"}},
+		},
+		{
+			name: "labels",
+			profiles: map[string]*profileConfig{
+				"trunk": {Labels: []string{"carrier", "trunk-id"}},
+			},
+			want: map[string][]string{"trunk": {"profile", "carrier", "trunk_id"}},
+		},
+		{
+			name: "no labels",
+			profiles: map[string]*profileConfig{
+				"caller": {Limit: 5},
+				"trunk":  nil,
+			},
+			want: map[string][]string{"caller": nil, "trunk": nil},
+		},
+		{
+			name: "invalid regexp",
+			profiles: map[string]*profileConfig{
+				"caller": {Regexp: `(?P<user>`},
+			},
+			wantErr: "dialog profile caller:",
+		},
+		{
+			name: "duplicate labels",
+			profiles: map[string]*profileConfig{
+				"trunk": {Labels: []string{"trunk-id", "trunk_id"}},
+			},
+			wantErr: "duplicate label trunk_id",
+		},
+		{
+			name: "profile label",
+			profiles: map[string]*profileConfig{
+				"trunk": {Labels: []string{"profile"}},
+			},
+			wantErr: "duplicate label profile",
+		},
+		{
+			name: "profiles with the same metric name",
+			profiles: map[string]*profileConfig{
+				"trunk-in": {Labels: []string{"carrier"}},
+				"trunk.in": {Labels: []string{"carrier"}},
+			},
+			wantErr: "map to the same metric",
+		},
+	}
+	for _, test := range tests {
+		pc := &profilesConfig{Profiles: test.profiles}
+		err := pc.compile()
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		for profile, want := range test.want {
+			config := pc.Profiles[profile]
+			if !reflect.DeepEqual(config.labelNames, want) {
+				t.Errorf("%s: %s labels %q, want %q", test.name, profile, config.labelNames, want)
+			}
+			if (config.desc != nil) != (want != nil) {
+				t.Errorf("%s: %s has desc %v", test.name, profile, config.desc)
+			}
+		}
+	}
+}
+
+func TestProfileLabelValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *profileConfig
+		value  string
+		want   []string
+		ok     bool
+	}{
+		{
+			name:   "regexp",
+			config: &profileConfig{Regexp: `^(?P<user>[^@]+)@(?P<domain>.+)$`},
+			value:  "alice@example.com",
+			want:   []string{"alice", "example.com"},
+			ok:     true,
+		},
+		{
+			name:   "regexp mismatch",
+			config: &profileConfig{Regexp: `^(?P<user>[^@]+)@(?P<domain>.+)$`},
+			value:  "alice",
+			ok:     false,
+		},
+		{
+			name:   "labels",
+			config: &profileConfig{Labels: []string{"carrier", "trunk-id"}},
+			value:  "trunk_id=7,carrier=acme",
+			want:   []string{"acme", "7"},
+			ok:     true,
+		},
+		{
+			name:   "missing labels are empty",
+			config: &profileConfig{Labels: []string{"carrier", "trunk-id"}},
+			value:  "carrier=acme,region=eu",
+			want:   []string{"acme", ""},
+			ok:     true,
+		},
+		{
+			name:   "no pairs",
+			config: &profileConfig{Labels: []string{"carrier"}},
+			value:  "acme",
+			ok:     false,
+		},
+	}
+	for _, test := range tests {
+		pc := &profilesConfig{Profiles: map[string]*profileConfig{"profile": test.config}}
+		if err := pc.compile(); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got, ok := test.config.labelValues(test.value)
+		if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: labelValues(%q) = %q, %v, want %q, %v", test.name, test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLabelCountsTop(t *testing.T) {
+	type add struct {
+		count  float64
+		labels []string
+	}
+	tests := []struct {
+		name  string
+		width int
+		adds  []add
+		other float64
+		limit int
+		want  []labeledCount
+	}{
+		{
+			name:  "no limit",
+			width: 1,
+			adds:  []add{{1, []string{"a"}}, {2, []string{"b"}}, {3, []string{"a"}}},
+			want:  []labeledCount{{[]string{"a"}, 4}, {[]string{"b"}, 2}},
+		},
+		{
+			name:  "under the limit",
+			width: 1,
+			adds:  []add{{1, []string{"a"}}, {2, []string{"b"}}},
+			limit: 2,
+			want:  []labeledCount{{[]string{"a"}, 1}, {[]string{"b"}, 2}},
+		},
+		{
+			name:  "over the limit",
+			width: 2,
+			adds: []add{
+				{1, []string{"a", "x"}},
+				{5, []string{"b", "x"}},
+				{3, []string{"a", "y"}},
+				{2, []string{"c", "y"}},
+			},
+			limit: 2,
+			want: []labeledCount{
+				{[]string{"b", "x"}, 5},
+				{[]string{"a", "y"}, 3},
+				{[]string{"other", "other"}, 3},
+			},
+		},
+		{
+			name:  "other counts",
+			width: 1,
+			adds:  []add{{1, []string{"a"}}},
+			other: 4,
+			want:  []labeledCount{{[]string{"a"}, 1}, {[]string{"other"}, 4}},
+		},
+		{
+			name:  "other label values",
+			width: 2,
+			adds:  []add{{1, []string{"other", "other"}}, {2, []string{"other", "x"}}},
+			want: []labeledCount{
+				{[]string{"other", "x"}, 2},
+				{[]string{"other", "other"}, 1},
+			},
+		},
+		{
+			name:  "other counts over the limit",
+			width: 1,
+			adds:  []add{{1, []string{"a"}}, {2, []string{"b"}}},
+			other: 4,
+			limit: 1,
+			want:  []labeledCount{{[]string{"b"}, 2}, {[]string{"other"}, 5}},
+		},
+	}
+	for _, test := range tests {
+		counts := newLabelCounts(test.width)
+		for _, a := range test.adds {
+			counts.add(a.count, a.labels...)
+		}
+		if test.other != 0 {
+			counts.addOther(test.other)
+		}
+		if got := counts.top(test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: top(%d) = %v, want %v", test.name, test.limit, got, test.want)
+		}
+	}
+}
+
+// MI responses of the dialog profile commands, by command and parameters
+var profileResponses = map[string]map[string]string{
+	"2.x": {
+		"list_all_profiles":         `{"inbound": "0", "caller": "1", "trunk": "1"}`,
+		"profile_get_size?inbound":  `{"profile": {"value": "", "attributes": {"name": "inbound", "value": "", "count": "2"}}}`,
+		"profile_get_values?caller": `{"value": [{"value": "alice@example.com", "attributes": {"count": "3"}}, {"value": "bob@example.com", "attributes": {"count": "1"}}]}`,
+		"profile_get_values?trunk":  `{"value": {"value": "carrier=acme", "attributes": {"count": "4"}}}`,
+	},
+	"3.x": {
+		"list_all_profiles":         `{"Profiles": [{"name": "inbound", "shared": "no", "has value": "no"}, {"name": "caller", "shared": "no", "has value": "yes"}, {"name": "trunk", "shared": "no", "has value": "yes"}]}`,
+		"profile_get_size?inbound":  `{"Profile": {"name": "inbound", "value": null, "count": 2}}`,
+		"profile_get_values?caller": `{"Values": [{"value": "alice@example.com", "count": 3}, {"value": "bob@example.com", "count": 1}]}`,
+		"profile_get_values?trunk":  `{"Values": [{"value": "carrier=acme", "count": 4}]}`,
+	},
+}
+
+func TestCollectDialogProfiles(t *testing.T) {
+	want := map[string]float64{
+		`opensips_dialog_profile_size{profile=inbound}`:                                      2,
+		`opensips_dialog_profiles_with_values_count{profile=caller,value=alice@example.com}`: 3,
+		`opensips_dialog_profiles_with_values_count{profile=caller,value=bob@example.com}`:   1,
+		`opensips_dialog_profile_trunk_count{carrier=acme,profile=trunk}`:                    4,
+	}
+
+	for version, responses := range profileResponses {
+		srv := newMIServer(responses)
+
+		conn, err := opensips_mi.NewMIJsonClient(srv.URL, opensips_mi.MIJsonConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		profiles := &profilesConfig{Profiles: map[string]*profileConfig{
+			"trunk": {Labels: []string{"carrier"}},
+		}}
+		if err := profiles.compile(); err != nil {
+			t.Fatal(err)
+		}
+		ose := newOpensipsExporter(srv.URL, exporterOptions{profiles: profiles})
+
+		ch := make(chan prometheus.Metric)
+		go func() {
+			ose.collectDialogProfiles(conn, ch, true)
+			close(ch)
+		}()
+		metrics := make(map[string]float64)
+		for metric := range ch {
+			key, value := metricKey(t, metric)
+			metrics[key] = value
+		}
+		srv.Close()
+
+		if !reflect.DeepEqual(metrics, want) {
+			t.Errorf("%s: got %v, want %v", version, metrics, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Coefficients of the ratelimit PID controller
+var ratelimitPidTerms = []string{"ki", "kp", "kd"}
+
+// Ratelimit pipes collector, based on rl_list and rl_get_pid
+type ratelimitCollector struct {
+	counter *prometheus.Desc
+	limit   *prometheus.Desc
+	pid     *prometheus.Desc
+}
+
+func init() {
+	registerCollector("ratelimit", "Export the ratelimit pipes, listed by rl_list.",
+		[]string{"rl_list"}, newRatelimitCollector)
+}
+
+func newRatelimitCollector() (collector, error) {
+	return &ratelimitCollector{
+		counter: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ratelimit", "pipe_counter"),
+			"Current counter of the ratelimit pipe",
+			[]string{"pipe", "algorithm"},
+			nil,
+		),
+		limit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ratelimit", "pipe_limit"),
+			"Limit of the ratelimit pipe",
+			[]string{"pipe", "algorithm"},
+			nil,
+		),
+		pid: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ratelimit", "pid_coefficient"),
+			"Coefficient of the ratelimit PID controller",
+			[]string{"term"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *ratelimitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.counter
+	ch <- c.limit
+	ch <- c.pid
+}
+
+func (c *ratelimitCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command("rl_list")
+	if err != nil {
+		return err
+	}
+
+	for _, node := range childList(resp, "PIPE", "Pipes") {
+		pipe := nodeValue(node, "id")
+		algorithm := strings.ToLower(node.Get("algorithm"))
+
+		if counter, err := strconv.ParseFloat(node.Get("counter"), 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.counter, prometheus.GaugeValue, counter, pipe, algorithm)
+		}
+		if limit, err := strconv.ParseFloat(node.Get("limit"), 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, limit, pipe, algorithm)
+		}
+	}
+
+	if t.commands["rl_get_pid"] {
+		resp, err = t.conn.Command("rl_get_pid")
+		if err != nil {
+			return err
+		}
+		if pid := resp.Child("PID"); pid != nil {
+			resp = pid
+		}
+		for _, term := range ratelimitPidTerms {
+			if value, err := strconv.ParseFloat(resp.Get(term), 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(c.pid, prometheus.GaugeValue, value, term)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"time"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	churnFlapWindow = flag.Duration("collector.registration-churn.window", 15*time.Minute,
+		"Window in which contact changes of an AoR are counted to detect flapping.")
+	churnFlapChanges = flag.Int("collector.registration-churn.flap-changes", 3,
+		"Number of contact changes within the window after which an AoR is considered flapping.")
+	churnFlappingLimit = flag.Int("collector.registration-churn.flapping-limit", 10,
+		"Maximum number of flapping AoRs to export by name. 0 exports none.")
+	churnMaxAors = flag.Int("collector.registration-churn.max-aors", 100000,
+		"Maximum number of AoRs to track the contacts and contact changes of.")
+)
+
+// Maximum number of recent contact changes tracked per AoR
+const maxAorChanges = 100
+
+// Contacts of an AoR, as hashes of their URI mapped to hashes of their Call-ID and CSeq
+type aorContacts map[uint64]uint64
+
+// Registration churn collector, diffing consecutive ul_dump snapshots
+type registrationChurnCollector struct {
+	// Contacts of the tracked AoRs, at the previous scrape and in the current walk
+	snapshot map[string]aorContacts
+	current  map[string]aorContacts
+	changes  map[string][]time.Time
+
+	// Hashes of the AoRs left out by the limit, in the previous and current walk
+	skipped        map[uint64]bool
+	currentSkipped map[uint64]bool
+
+	added     float64
+	removed   float64
+	refreshed float64
+
+	addedDesc       *prometheus.Desc
+	removedDesc     *prometheus.Desc
+	refreshedDesc   *prometheus.Desc
+	flappingDesc    *prometheus.Desc
+	flappingAorDesc *prometheus.Desc
+	trackedDesc     *prometheus.Desc
+}
+
+func init() {
+	registerCollector("registration-churn", "Export registration churn, diffing consecutive ul_dump snapshots.",
+		[]string{"ul_dump"}, newRegistrationChurnCollector)
+}
+
+func newRegistrationChurnCollector() (collector, error) {
+	return &registrationChurnCollector{
+		changes: make(map[string][]time.Time),
+
+		addedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_added_total"),
+			"Total number of contacts added between scrapes",
+			nil,
+			nil,
+		),
+		removedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_removed_total"),
+			"Total number of contacts removed between scrapes",
+			nil,
+			nil,
+		),
+		refreshedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_refreshed_total"),
+			"Total number of contacts refreshed between scrapes",
+			nil,
+			nil,
+		),
+		flappingDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "flapping_aors"),
+			"Number of AoRs whose contacts changed more than the flapping threshold within the window",
+			nil,
+			nil,
+		),
+		flappingAorDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "flapping_aor_changes"),
+			"Number of contact changes within the window of the most flapping AoRs",
+			[]string{"aor"},
+			nil,
+		),
+		trackedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "churn_tracked_aors"),
+			"Number of AoRs whose recent contact changes are tracked",
+			nil,
+			nil,
+		),
+	}, nil
+}
+
+func (c *registrationChurnCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.addedDesc
+	ch <- c.removedDesc
+	ch <- c.refreshedDesc
+	ch <- c.flappingDesc
+	ch <- c.flappingAorDesc
+	ch <- c.trackedDesc
+}
+
+func hashString(values ...string) uint64 {
+	h := fnv.New64a()
+	for _, value := range values {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+func (c *registrationChurnCollector) walkCommand() string {
+	return "ul_dump"
+}
+
+func (c *registrationChurnCollector) newWalk() func(*opensips_mi.MINode) error {
+	c.current = make(map[string]aorContacts)
+	c.currentSkipped = make(map[uint64]bool)
+	var added int
+
+	return walkAors(func(aor string, contacts []contact) {
+		if len(contacts) == 0 {
+			return
+		}
+		key := contacts[0].domain + ":" + aor
+
+		// Keep tracking the AoRs of the previous snapshot, so that no AoR
+		// is seen as removed because of the limit
+		if _, tracked := c.snapshot[key]; !tracked {
+			if len(c.snapshot)+added >= *churnMaxAors {
+				c.currentSkipped[hashString(key)] = true
+				return
+			}
+			added++
+		}
+
+		current := make(aorContacts, len(contacts))
+		for _, contact := range contacts {
+			current[hashString(contact.uri)] = hashString(contact.callid, contact.cseq)
+		}
+		c.current[key] = current
+	})
+}
+
+func (c *registrationChurnCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	if err := t.walk("ul_dump"); err != nil {
+		return err
+	}
+	snapshot, skipped := c.current, c.currentSkipped
+	c.current, c.currentSkipped = nil, nil
+
+	now := time.Now()
+	if c.snapshot != nil {
+		for aor, current := range snapshot {
+			previous, tracked := c.snapshot[aor]
+			if !tracked && c.skipped[hashString(aor)] {
+				// The contacts of an AoR left out by the limit are unknown
+				// until it is tracked
+				continue
+			}
+			c.diff(aor, previous, current, now)
+		}
+		for aor, previous := range c.snapshot {
+			if _, exists := snapshot[aor]; !exists {
+				c.diff(aor, previous, nil, now)
+			}
+		}
+	}
+	c.snapshot, c.skipped = snapshot, skipped
+
+	// Forget the changes that left the window
+	flapping := newLabelCounts(1)
+	var flappingAors float64
+	for aor, times := range c.changes {
+		i := 0
+		for i < len(times) && now.Sub(times[i]) > *churnFlapWindow {
+			i++
+		}
+		if i == len(times) {
+			delete(c.changes, aor)
+			continue
+		}
+		c.changes[aor] = times[i:]
+
+		if len(times)-i > *churnFlapChanges {
+			flappingAors++
+			flapping.add(float64(len(times)-i), aor)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.addedDesc, prometheus.CounterValue, c.added)
+	ch <- prometheus.MustNewConstMetric(c.removedDesc, prometheus.CounterValue, c.removed)
+	ch <- prometheus.MustNewConstMetric(c.refreshedDesc, prometheus.CounterValue, c.refreshed)
+	ch <- prometheus.MustNewConstMetric(c.flappingDesc, prometheus.GaugeValue, flappingAors)
+	ch <- prometheus.MustNewConstMetric(c.trackedDesc, prometheus.GaugeValue, float64(len(c.changes)))
+
+	if *churnFlappingLimit > 0 {
+		// Only export the most flapping AoRs, without aggregating the rest
+		counts := flapping.top(*churnFlappingLimit)
+		for _, value := range counts {
+			if value.labels[0] != otherLabelValue {
+				ch <- prometheus.MustNewConstMetric(c.flappingAorDesc, prometheus.GaugeValue, value.count, value.labels...)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Count the contacts added, removed and refreshed for an AoR between two snapshots.
+func (c *registrationChurnCollector) diff(aor string, previous, current aorContacts, now time.Time) {
+	changed := false
+	for uri, registration := range current {
+		if prev, exists := previous[uri]; !exists {
+			c.added++
+			changed = true
+		} else if prev != registration {
+			c.refreshed++
+		}
+	}
+	for uri := range previous {
+		if _, exists := current[uri]; !exists {
+			c.removed++
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+	if _, tracked := c.changes[aor]; !tracked && len(c.changes) >= *churnMaxAors {
+		return
+	}
+
+	times := append(c.changes[aor], now)
+	if len(times) > maxAorChanges {
+		times = times[len(times)-maxAorChanges:]
+	}
+	c.changes[aor] = times
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Build a ul_dump response of the location domain from AoRs and their
+// contacts, as URI and Call-ID pairs.
+func ulDump(t *testing.T, aors ...map[string][][2]string) string {
+	type contact struct {
+		Contact string
+		Callid  string
+		Cseq    string
+		Expires int
+	}
+	type aor struct {
+		AOR      string
+		Contacts []contact
+	}
+	var list []aor
+	for _, m := range aors {
+		for name, contacts := range m {
+			a := aor{AOR: name}
+			for _, c := range contacts {
+				a.Contacts = append(a.Contacts, contact{Contact: c[0], Callid: c[1], Cseq: "1", Expires: 3600})
+			}
+			list = append(list, a)
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"Domains": []interface{}{map[string]interface{}{"name": "location", "AORs": list}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+type churnStep struct {
+	// AoRs listed by ul_dump, one map per AoR to keep them in order
+	aors []map[string][][2]string
+	want map[string]float64
+}
+
+var registrationChurnTests = []struct {
+	name        string
+	maxAors     int
+	flapChanges int
+	steps       []churnStep
+}{
+	{
+		name:        "added, removed and refreshed",
+		maxAors:     100,
+		flapChanges: 10,
+		steps: []churnStep{
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.1", "1"}}}},
+				want: map[string]float64{"added": 0, "removed": 0, "refreshed": 0},
+			},
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.1", "2"}, {"sip:alice@10.0.0.2", "1"}}}},
+				want: map[string]float64{"added": 1, "removed": 0, "refreshed": 1},
+			},
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.2", "1"}}}, {"bob": {{"sip:bob@10.0.0.3", "1"}}}},
+				want: map[string]float64{"added": 2, "removed": 1, "refreshed": 1},
+			},
+			{
+				aors: nil,
+				want: map[string]float64{"added": 2, "removed": 3, "refreshed": 1},
+			},
+		},
+	},
+	{
+		name:        "AoRs beyond the limit",
+		maxAors:     1,
+		flapChanges: 10,
+		steps: []churnStep{
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.1", "1"}}}, {"bob": {{"sip:bob@10.0.0.2", "1"}}}},
+				want: map[string]float64{"added": 0, "removed": 0, "tracked": 0},
+			},
+			{
+				// alice keeps her slot until the next walk
+				aors: []map[string][][2]string{{"bob": {{"sip:bob@10.0.0.2", "1"}}}},
+				want: map[string]float64{"added": 0, "removed": 1, "tracked": 1},
+			},
+			{
+				// bob was left out, so none of his contacts are new
+				aors: []map[string][][2]string{{"bob": {{"sip:bob@10.0.0.2", "1"}}}},
+				want: map[string]float64{"added": 0, "removed": 1, "tracked": 1},
+			},
+			{
+				aors: []map[string][][2]string{{"bob": {{"sip:bob@10.0.0.2", "1"}, {"sip:bob@10.0.0.3", "1"}}}, {"carol": {{"sip:carol@10.0.0.4", "1"}}}},
+				want: map[string]float64{"added": 1, "removed": 1, "tracked": 1},
+			},
+		},
+	},
+	{
+		name:        "flapping",
+		maxAors:     100,
+		flapChanges: 2,
+		steps: []churnStep{
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.1", "1"}}}},
+				want: map[string]float64{"flapping": 0},
+			},
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.2", "1"}}}},
+				want: map[string]float64{"flapping": 0, "tracked": 1},
+			},
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.1", "1"}}}},
+				want: map[string]float64{"flapping": 0, "tracked": 1},
+			},
+			{
+				aors: []map[string][][2]string{{"alice": {{"sip:alice@10.0.0.2", "1"}}}},
+				want: map[string]float64{"flapping": 1, "tracked": 1, "alice": 3},
+			},
+		},
+	},
+}
+
+var churnMetrics = map[string]string{
+	"added":     `opensips_registrations_contacts_added_total{}`,
+	"removed":   `opensips_registrations_contacts_removed_total{}`,
+	"refreshed": `opensips_registrations_contacts_refreshed_total{}`,
+	"flapping":  `opensips_registrations_flapping_aors{}`,
+	"tracked":   `opensips_registrations_churn_tracked_aors{}`,
+	"alice":     `opensips_registrations_flapping_aor_changes{aor=location:alice}`,
+}
+
+func setChurnFlags(maxAors, flapChanges int) func() {
+	prevMaxAors, prevFlapChanges := *churnMaxAors, *churnFlapChanges
+	*churnMaxAors, *churnFlapChanges = maxAors, flapChanges
+	return func() {
+		*churnMaxAors, *churnFlapChanges = prevMaxAors, prevFlapChanges
+	}
+}
+
+func TestRegistrationChurnCollector(t *testing.T) {
+	for _, test := range registrationChurnTests {
+		restore := setChurnFlags(test.maxAors, test.flapChanges)
+		c := mustCollector(t, newRegistrationChurnCollector)
+
+		for i, step := range test.steps {
+			metrics := gatherCollector(t, c, map[string]string{"ul_dump": ulDump(t, step.aors...)})
+			for name, want := range step.want {
+				if got := metrics[churnMetrics[name]]; got != want {
+					t.Errorf("%s: step %d: %s = %v, want %v", test.name, i, name, got, want)
+				}
+			}
+		}
+		restore()
+	}
+}
+
+// Changes leaving the window no longer count towards flapping.
+func TestRegistrationChurnWindow(t *testing.T) {
+	defer setChurnFlags(100, 0)()
+	c := mustCollector(t, newRegistrationChurnCollector)
+
+	dumps := []string{
+		ulDump(t, map[string][][2]string{"alice": {{"sip:alice@10.0.0.1", "1"}}}),
+		ulDump(t, map[string][][2]string{"alice": {{"sip:alice@10.0.0.2", "1"}}}),
+	}
+	for _, dump := range dumps {
+		gatherCollector(t, c, map[string]string{"ul_dump": dump})
+	}
+
+	churn := c.(*registrationChurnCollector)
+	for aor, times := range churn.changes {
+		for i := range times {
+			times[i] = times[i].Add(-2 * *churnFlapWindow)
+		}
+		churn.changes[aor] = times
+	}
+
+	metrics := gatherCollector(t, c, map[string]string{"ul_dump": dumps[1]})
+	for _, name := range []string{"flapping", "tracked"} {
+		if got := metrics[churnMetrics[name]]; got != 0 {
+			t.Errorf("%s = %v, want 0", name, got)
+		}
+	}
+	if len(churn.changes) != 0 {
+		t.Errorf("changes of %d AoRs kept", len(churn.changes))
+	}
+}
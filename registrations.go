@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	registrationsUserAgentsLimit = flag.Int("collector.registrations.user-agents-limit", 20,
+		"Maximum number of user agent families to export per domain. 0 disables the limit.")
+	registrationsTransportsLimit = flag.Int("collector.registrations.transports-limit", 10,
+		"Maximum number of transports to export per domain. 0 disables the limit.")
+)
+
+var (
+	contactsPerAorBuckets = []float64{1, 2, 3, 5, 10, 20}
+	contactExpiryBuckets  = []float64{60, 300, 600, 1800, 3600, 7200, 86400}
+)
+
+// Registered contact, as listed by ul_dump
+type contact struct {
+	domain    string
+	aor       string
+	uri       string
+	callid    string
+	cseq      string
+	expires   string
+	received  string
+	socket    string
+	userAgent string
+}
+
+// Return the function walking the usrloc domains listed by ul_dump, calling fn
+// with the contacts of every AoR.
+func walkAors(fn func(aor string, contacts []contact)) func(*opensips_mi.MINode) error {
+	return func(domainNode *opensips_mi.MINode) error {
+		domain := nodeValue(domainNode, "name")
+
+		for _, aorNode := range childList(domainNode, "AOR", "AORs") {
+			aor := nodeValue(aorNode, "AOR")
+
+			var contacts []contact
+			for _, node := range childList(aorNode, "Contact", "Contacts") {
+				uri := nodeValue(node, "Contact")
+				contacts = append(contacts, contact{
+					domain:    domain,
+					aor:       aor,
+					uri:       uri,
+					callid:    node.Get("Callid"),
+					cseq:      node.Get("Cseq"),
+					expires:   node.Get("Expires"),
+					received:  node.Get("Received"),
+					socket:    node.Get("Socket"),
+					userAgent: node.Get("User-agent"),
+				})
+			}
+			fn(aor, contacts)
+		}
+		return nil
+	}
+}
+
+var uriTransportRegexp = regexp.MustCompile(`(?i);transport=([a-z]+)`)
+
+// Return the transport the contact registered over.
+func (c *contact) transport() string {
+	if i := strings.Index(c.socket, ":"); i > 0 {
+		return strings.ToLower(c.socket[:i])
+	}
+	if m := uriTransportRegexp.FindStringSubmatch(c.uri); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return "udp"
+}
+
+// Check if the contact is behind NAT, either detected by OpenSIPS or advertising a private address.
+func (c *contact) natted() bool {
+	if c.received != "" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(uriDomain(c.uri), "[]"))
+	return ip != nil && isPrivateIP(ip)
+}
+
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var userAgentFamilyRegexp = regexp.MustCompile(`[a-zA-Z]+`)
+
+// Return the user agent family, like "yealink" for "Yealink SIP-T46S 66.84.0.15".
+func (c *contact) userAgentFamily() string {
+	family := userAgentFamilyRegexp.FindString(c.userAgent)
+	if family == "" {
+		return "unknown"
+	}
+	return strings.ToLower(family)
+}
+
+// Return the remaining time until the contact expires, or false if it never does.
+func (c *contact) expiresIn() (float64, bool) {
+	expires, err := strconv.ParseFloat(c.expires, 64)
+	return expires, err == nil
+}
+
+// Registration details collector, based on ul_dump
+type registrationsCollector struct {
+	// Registrations by usrloc domain, filled by the current walk
+	domains map[string]*domainRegistrations
+
+	byTransport *prometheus.Desc
+	byUserAgent *prometheus.Desc
+	byNat       *prometheus.Desc
+	perAor      *prometheus.Desc
+	expiry      *prometheus.Desc
+}
+
+func init() {
+	registerCollector("registrations", "Export details of the registered contacts, walking ul_dump.",
+		[]string{"ul_dump"}, newRegistrationsCollector)
+}
+
+func newRegistrationsCollector() (collector, error) {
+	return &registrationsCollector{
+		byTransport: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_by_transport"),
+			"Number of registered contacts by transport",
+			[]string{"domain", "transport"},
+			nil,
+		),
+		byUserAgent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_by_user_agent"),
+			"Number of registered contacts by user agent family",
+			[]string{"domain", "user_agent"},
+			nil,
+		),
+		byNat: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_by_nat"),
+			"Number of registered contacts behind NAT or not",
+			[]string{"domain", "nat"},
+			nil,
+		),
+		perAor: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contacts_per_aor"),
+			"Number of registered contacts per AoR",
+			[]string{"domain"},
+			nil,
+		),
+		expiry: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "registrations", "contact_expiry_seconds"),
+			"Remaining time until the registered contacts expire",
+			[]string{"domain"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *registrationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.byTransport
+	ch <- c.byUserAgent
+	ch <- c.byNat
+	ch <- c.perAor
+	ch <- c.expiry
+}
+
+// Registration details of a usrloc domain
+type domainRegistrations struct {
+	transports *labelCounts
+	userAgents *labelCounts
+	natted     float64
+	public     float64
+	perAor     *constHistogram
+	expiry     *constHistogram
+}
+
+func (c *registrationsCollector) walkCommand() string {
+	return "ul_dump"
+}
+
+func (c *registrationsCollector) newWalk() func(*opensips_mi.MINode) error {
+	c.domains = make(map[string]*domainRegistrations)
+
+	return walkAors(func(aor string, contacts []contact) {
+		if len(contacts) == 0 {
+			return
+		}
+		domain := contacts[0].domain
+		regs, exists := c.domains[domain]
+		if !exists {
+			regs = &domainRegistrations{
+				transports: newLabelCounts(1),
+				userAgents: newLabelCounts(1),
+				perAor:     newConstHistogram(contactsPerAorBuckets),
+				expiry:     newConstHistogram(contactExpiryBuckets),
+			}
+			c.domains[domain] = regs
+		}
+
+		regs.perAor.observe(float64(len(contacts)))
+		for _, contact := range contacts {
+			regs.transports.add(1, contact.transport())
+			regs.userAgents.add(1, contact.userAgentFamily())
+			if contact.natted() {
+				regs.natted++
+			} else {
+				regs.public++
+			}
+			if expires, ok := contact.expiresIn(); ok {
+				regs.expiry.observe(expires)
+			}
+		}
+	})
+}
+
+func (c *registrationsCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	if err := t.walk("ul_dump"); err != nil {
+		return err
+	}
+
+	for domain, regs := range c.domains {
+		for _, value := range regs.transports.top(*registrationsTransportsLimit) {
+			ch <- prometheus.MustNewConstMetric(c.byTransport, prometheus.GaugeValue, value.count, domain, value.labels[0])
+		}
+		for _, value := range regs.userAgents.top(*registrationsUserAgentsLimit) {
+			ch <- prometheus.MustNewConstMetric(c.byUserAgent, prometheus.GaugeValue, value.count, domain, value.labels[0])
+		}
+		ch <- prometheus.MustNewConstMetric(c.byNat, prometheus.GaugeValue, regs.natted, domain, "true")
+		ch <- prometheus.MustNewConstMetric(c.byNat, prometheus.GaugeValue, regs.public, domain, "false")
+		ch <- regs.perAor.metric(c.perAor, domain)
+		ch <- regs.expiry.metric(c.expiry, domain)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Listening sockets collector, based on sockets_list or list_sockets
+type socketsCollector struct {
+	info *prometheus.Desc
+}
+
+func init() {
+	registerCollector("sockets", "Export the listening sockets, listed by sockets_list or list_sockets.",
+		[]string{"sockets_list", "list_sockets"}, newSocketsCollector)
+}
+
+func newSocketsCollector() (collector, error) {
+	return &socketsCollector{
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "socket_info"),
+			"Listening socket, with its advertised address and tag",
+			[]string{"proto", "address", "port", "advertised", "tag"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *socketsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+}
+
+// Split a socket reported as proto:address:port.
+func splitSocket(socket string) (proto string, address string, port string) {
+	if i := strings.Index(socket, ":"); i >= 0 {
+		proto, socket = socket[:i], socket[i+1:]
+	}
+	if host, p, err := net.SplitHostPort(socket); err == nil {
+		return proto, host, p
+	}
+	return proto, socket, ""
+}
+
+func (c *socketsCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command(t.command("sockets_list", "list_sockets"))
+	if err != nil {
+		return err
+	}
+
+	exported := make(map[string]bool)
+	for _, node := range childList(resp, "Sockets", "Socket") {
+		proto := nodeField(node, "proto", "Proto")
+		address := nodeField(node, "address", "Address", "IP")
+		port := nodeField(node, "port", "Port")
+		if address == "" {
+			// Older versions list the sockets as proto:address:port
+			proto, address, port = splitSocket(node.Value)
+		}
+
+		advertised := nodeField(node, "advertised", "Advertised", "advertised_address", "Advertised IP")
+		if advertisedPort := nodeField(node, "advertised_port", "Advertised port"); advertisedPort != "" {
+			advertised = net.JoinHostPort(advertised, advertisedPort)
+		}
+		tag := nodeField(node, "tag", "Tag")
+
+		labels := []string{strings.ToLower(proto), address, port, advertised, tag}
+		key := strings.Join(labels, "\xff")
+		if exported[key] {
+			continue
+		}
+		exported[key] = true
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, labels...)
+	}
+
+	return nil
+}
@@ -13,6 +13,66 @@ type stat struct {
 	value  prometheus.ValueType
 	help   string
 	desc   *prometheus.Desc
+
+	// First OpenSIPS version reporting the stat, as major*100+minor, 0 if any
+	since int
+
+	// How the per-process stat is aggregated by process role, when joining processes
+	aggregate    aggregation
+	roleHelp     string
+	processLabel int
+	joinedDesc   *prometheus.Desc
+	roleDesc     *prometheus.Desc
+}
+
+type aggregation int
+
+const (
+	noAggregation aggregation = iota
+	sumAggregation
+	avgAggregation
+)
+
+// A per-process stat aggregated for one process role
+type roleKey struct {
+	stat *stat
+	role string
+}
+
+type roleAggregate struct {
+	sum   float64
+	count int
+}
+
+type roleAggregates map[roleKey]*roleAggregate
+
+func (ra roleAggregates) add(stat *stat, role string, value float64) {
+	key := roleKey{stat, role}
+	agg, exists := ra[key]
+	if !exists {
+		agg = &roleAggregate{}
+		ra[key] = agg
+	}
+	agg.sum += value
+	agg.count++
+}
+
+func (agg *roleAggregate) value(stat *stat) float64 {
+	if stat.aggregate == avgAggregation {
+		return agg.sum / float64(agg.count)
+	}
+	return agg.sum
+}
+
+// Stats reported for shared memory groups are prefixed by this
+const shmemGroupPrefix = "shmem_group_"
+
+// Check if the stat is reported by an OpenSIPS version, assuming it is if the version is unknown.
+func (s *stat) supports(version int) bool {
+	if version == 0 {
+		return true
+	}
+	return version >= s.since
 }
 
 var opensipsStats = map[string][]stat{
@@ -148,10 +208,58 @@ var opensipsStats = map[string][]stat{
 			help:  "The real time load of all OpenSIPS processes",
 		},
 		{
-			name:   "process_load",
-			regexp: regexp.MustCompile(`^load-proc-(?P<id>\d+)$`),
-			value:  prometheus.GaugeValue,
-			help:   "he real time load of the OpenSIPS process #id",
+			name:      "process_load",
+			regexp:    regexp.MustCompile(`^load-proc-(?P<id>\d+)$`),
+			value:     prometheus.GaugeValue,
+			help:      "The real time load of the OpenSIPS process #id",
+			aggregate: avgAggregation,
+			roleHelp:  "The average real time load of the OpenSIPS processes of the role",
+		},
+		{
+			name:  "load_1m",
+			stat:  "load1m",
+			value: prometheus.GaugeValue,
+			help:  "The average load of core OpenSIPS processes over the last minute",
+			since: 300,
+		},
+		{
+			name:  "load_10m",
+			stat:  "load10m",
+			value: prometheus.GaugeValue,
+			help:  "The average load of core OpenSIPS processes over the last 10 minutes",
+			since: 300,
+		},
+		{
+			name:  "load_all_1m",
+			stat:  "load1m-all",
+			value: prometheus.GaugeValue,
+			help:  "The average load of all OpenSIPS processes over the last minute",
+			since: 300,
+		},
+		{
+			name:  "load_all_10m",
+			stat:  "load10m-all",
+			value: prometheus.GaugeValue,
+			help:  "The average load of all OpenSIPS processes over the last 10 minutes",
+			since: 300,
+		},
+		{
+			name:      "process_load_1m",
+			regexp:    regexp.MustCompile(`^load1m-proc-(?P<id>\d+)$`),
+			value:     prometheus.GaugeValue,
+			help:      "The average load of the OpenSIPS process #id over the last minute",
+			aggregate: avgAggregation,
+			roleHelp:  "The average load of the OpenSIPS processes of the role over the last minute",
+			since:     300,
+		},
+		{
+			name:      "process_load_10m",
+			regexp:    regexp.MustCompile(`^load10m-proc-(?P<id>\d+)$`),
+			value:     prometheus.GaugeValue,
+			help:      "The average load of the OpenSIPS process #id over the last 10 minutes",
+			aggregate: avgAggregation,
+			roleHelp:  "The average load of the OpenSIPS processes of the role over the last 10 minutes",
+			since:     300,
 		},
 	},
 	"msilo": {
@@ -222,40 +330,52 @@ var opensipsStats = map[string][]stat{
 	},
 	"pkmem": {
 		{
-			name:   "total_size_bytes",
-			regexp: regexp.MustCompile(`^(?P<id>\d+)-total_size$`),
-			value:  prometheus.GaugeValue,
-			help:   "The total size of private memory available to OpenSIPS process #id",
+			name:      "total_size_bytes",
+			regexp:    regexp.MustCompile(`^(?P<id>\d+)-total_size$`),
+			value:     prometheus.GaugeValue,
+			help:      "The total size of private memory available to OpenSIPS process #id",
+			aggregate: sumAggregation,
+			roleHelp:  "The total size of private memory available to the OpenSIPS processes of the role",
 		},
 		{
-			name:   "used_size_bytes",
-			regexp: regexp.MustCompile(`^(?P<id>\d+)-used_size$`),
-			value:  prometheus.GaugeValue,
-			help:   "The total size of private memory used by OpenSIPS process #id",
+			name:      "used_size_bytes",
+			regexp:    regexp.MustCompile(`^(?P<id>\d+)-used_size$`),
+			value:     prometheus.GaugeValue,
+			help:      "The total size of private memory used by OpenSIPS process #id",
+			aggregate: sumAggregation,
+			roleHelp:  "The total size of private memory used by the OpenSIPS processes of the role",
 		},
 		{
-			name:   "real_used_size_bytes",
-			regexp: regexp.MustCompile(`^(?P<id>\d+)-real_used_size$`),
-			value:  prometheus.GaugeValue,
-			help:   "The total size of private memory (including overhead) used by OpenSIPS process #id",
+			name:      "real_used_size_bytes",
+			regexp:    regexp.MustCompile(`^(?P<id>\d+)-real_used_size$`),
+			value:     prometheus.GaugeValue,
+			help:      "The total size of private memory (including overhead) used by OpenSIPS process #id",
+			aggregate: sumAggregation,
+			roleHelp:  "The total size of private memory (including overhead) used by the OpenSIPS processes of the role",
 		},
 		{
-			name:   "max_used_size_bytes",
-			regexp: regexp.MustCompile(`^(?P<id>\d+)-max_used_size$`),
-			value:  prometheus.GaugeValue,
-			help:   "The maximum amount of private memory ever used by OpenSIPS process #id",
+			name:      "max_used_size_bytes",
+			regexp:    regexp.MustCompile(`^(?P<id>\d+)-max_used_size$`),
+			value:     prometheus.GaugeValue,
+			help:      "The maximum amount of private memory ever used by OpenSIPS process #id",
+			aggregate: sumAggregation,
+			roleHelp:  "The sum of the maximum amounts of private memory ever used by the OpenSIPS processes of the role",
 		},
 		{
-			name:   "free_size_bytes",
-			regexp: regexp.MustCompile(`^(?P<id>\d+)-free_size$`),
-			value:  prometheus.GaugeValue,
-			help:   "The free private memory available for OpenSIPS process #id",
+			name:      "free_size_bytes",
+			regexp:    regexp.MustCompile(`^(?P<id>\d+)-free_size$`),
+			value:     prometheus.GaugeValue,
+			help:      "The free private memory available for OpenSIPS process #id",
+			aggregate: sumAggregation,
+			roleHelp:  "The free private memory available for the OpenSIPS processes of the role",
 		},
 		{
-			name:   "fragments",
-			regexp: regexp.MustCompile(`^(?P<id>\d+)-fragments$`),
-			value:  prometheus.GaugeValue,
-			help:   "The number of fragments in the private memory for OpenSIPS process #",
+			name:      "fragments",
+			regexp:    regexp.MustCompile(`^(?P<id>\d+)-fragments$`),
+			value:     prometheus.GaugeValue,
+			help:      "The number of fragments in the private memory for OpenSIPS process #id",
+			aggregate: sumAggregation,
+			roleHelp:  "The number of fragments in the private memory of the OpenSIPS processes of the role",
 		},
 	},
 	"registrar": {
@@ -279,13 +399,13 @@ var opensipsStats = map[string][]stat{
 		},
 		{
 			name:  "accepted_registrations_total",
-			stat:  "accepted_registrations",
+			stat:  "accepted_regs",
 			value: prometheus.CounterValue,
 			help:  "Total number of accepted registrations",
 		},
 		{
 			name:  "rejected_registrations_total",
-			stat:  "rejected_registrations",
+			stat:  "rejected_regs",
 			value: prometheus.CounterValue,
 			help:  "Total number of rejected registrations",
 		},
@@ -328,6 +448,50 @@ var opensipsStats = map[string][]stat{
 			help:  "The number of fragments in the shared memory used by OpenSIPS processes",
 		},
 	},
+	"shmem_group": {
+		{
+			name:   "total_size_bytes",
+			regexp: regexp.MustCompile(`^(?P<group>[^:]+):total_size$`),
+			value:  prometheus.GaugeValue,
+			help:   "The total size of shared memory available to the OpenSIPS shared memory group",
+			since:  300,
+		},
+		{
+			name:   "used_size_bytes",
+			regexp: regexp.MustCompile(`^(?P<group>[^:]+):used_size$`),
+			value:  prometheus.GaugeValue,
+			help:   "The total size of shared memory used by the OpenSIPS shared memory group",
+			since:  300,
+		},
+		{
+			name:   "real_used_size_bytes",
+			regexp: regexp.MustCompile(`^(?P<group>[^:]+):real_used_size$`),
+			value:  prometheus.GaugeValue,
+			help:   "The total size of shared memory used (including overhead) by the OpenSIPS shared memory group",
+			since:  300,
+		},
+		{
+			name:   "max_used_size_bytes",
+			regexp: regexp.MustCompile(`^(?P<group>[^:]+):max_used_size$`),
+			value:  prometheus.GaugeValue,
+			help:   "The maximum amount of shared memory used by the OpenSIPS shared memory group",
+			since:  300,
+		},
+		{
+			name:   "free_size_bytes",
+			regexp: regexp.MustCompile(`^(?P<group>[^:]+):free_size$`),
+			value:  prometheus.GaugeValue,
+			help:   "The amount of free shared memory available to the OpenSIPS shared memory group",
+			since:  300,
+		},
+		{
+			name:   "fragments",
+			regexp: regexp.MustCompile(`^(?P<group>[^:]+):fragments$`),
+			value:  prometheus.GaugeValue,
+			help:   "The number of fragments in the shared memory used by the OpenSIPS shared memory group",
+			since:  300,
+		},
+	},
 	"sipcapture": {
 		{
 			name:  "captured_requests_total",
@@ -455,6 +619,24 @@ var opensipsStats = map[string][]stat{
 			value: prometheus.GaugeValue,
 			help:  "Current number of registered users",
 		},
+		{
+			name:   "users",
+			regexp: regexp.MustCompile(`^(?P<domain>.+)-users$`),
+			value:  prometheus.GaugeValue,
+			help:   "Current number of registered users in the usrloc domain",
+		},
+		{
+			name:   "contacts",
+			regexp: regexp.MustCompile(`^(?P<domain>.+)-contacts$`),
+			value:  prometheus.GaugeValue,
+			help:   "Current number of registered contacts in the usrloc domain",
+		},
+		{
+			name:   "expired_contacts_total",
+			regexp: regexp.MustCompile(`^(?P<domain>.+)-expires$`),
+			value:  prometheus.CounterValue,
+			help:   "Total number of expired contacts in the usrloc domain",
+		},
 	},
 }
 
@@ -474,6 +656,27 @@ func init() {
 					nil,
 				)
 			}
+			if stat.aggregate != noAggregation && stat.joinedDesc == nil {
+				labels := append([]string{}, stat.regexp.SubexpNames()[1:]...)
+				for j, label := range labels {
+					if label == "id" {
+						stats[i].processLabel = j
+					}
+				}
+
+				stats[i].joinedDesc = prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsys, stat.name),
+					stat.help,
+					append(labels, "type", "role"),
+					nil,
+				)
+				stats[i].roleDesc = prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsys, "role_"+stat.name),
+					stat.roleHelp,
+					[]string{"role"},
+					nil,
+				)
+			}
 		}
 	}
 }
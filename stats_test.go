@@ -0,0 +1,260 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var descNameRegexp = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// Render a metric as its name and labels, like `opensips_core_uptime_seconds_total{}`.
+func metricKey(t *testing.T, metric prometheus.Metric) (string, float64) {
+	m := descNameRegexp.FindStringSubmatch(metric.Desc().String())
+	if m == nil {
+		t.Fatalf("no name in %s", metric.Desc())
+	}
+
+	var pb dto.Metric
+	if err := metric.Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	labels := make([]string, 0, len(pb.Label))
+	for _, label := range pb.Label {
+		labels = append(labels, label.GetName()+"="+label.GetValue())
+	}
+	sort.Strings(labels)
+
+	var value float64
+	switch {
+	case pb.Counter != nil:
+		value = pb.Counter.GetValue()
+	case pb.Gauge != nil:
+		value = pb.Gauge.GetValue()
+	case pb.Untyped != nil:
+		value = pb.Untyped.GetValue()
+	}
+	return m[1] + "{" + strings.Join(labels, ",") + "}", value
+}
+
+// Run collectStats against a get_statistics fixture, returning the exported metrics by key.
+func collectStatsFixture(t *testing.T, fixture string, version int, processes []process) (map[string]float64, float64) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	conn, err := opensips_mi.NewMIJsonClient(srv.URL, opensips_mi.MIJsonConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ose := newOpensipsExporter(srv.URL, exporterOptions{joinProcesses: processes != nil})
+	ose.version = version
+	ose.processes = processes
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan float64, 1)
+	go func() {
+		done <- ose.collectStats(conn, ch)
+		close(ch)
+	}()
+
+	metrics := make(map[string]float64)
+	for metric := range ch {
+		key, value := metricKey(t, metric)
+		if _, exists := metrics[key]; exists {
+			t.Errorf("%s: %s exported twice", fixture, key)
+		}
+		metrics[key] = value
+	}
+	return metrics, <-done
+}
+
+var collectStatsTests = []struct {
+	fixture   string
+	version   int
+	processes []process
+	uptime    float64
+	want      map[string]float64
+	absent    []string
+}{
+	{
+		fixture: "get_statistics-2.4.json",
+		version: 204,
+		uptime:  3600,
+		want: map[string]float64{
+			`opensips_core_received_requests_total{}`:                           1200,
+			`opensips_core_uptime_seconds_total{}`:                              3600,
+			`opensips_shmem_used_size_bytes{}`:                                  1048576,
+			`opensips_load_process_load{id=1}`:                                  5,
+			`opensips_pkmem_used_size_bytes{id=1}`:                              131072,
+			`opensips_sl_sent_replies{code=2xx}`:                                400,
+			`opensips_tm_transactions_total{code=4xx}`:                          100,
+			`opensips_tm_inuse_transactions{}`:                                  3,
+			`opensips_dialog_active_dialogs{}`:                                  4,
+			`opensips_dialog_replication_messages_sent_total{operation=create}`: 30,
+			`opensips_dialog_replication_messages_sent_total{operation=update}`: 12,
+			`opensips_registrar_accepted_registrations_total{}`:                 25,
+			`opensips_registrar_rejected_registrations_total{}`:                 1,
+			`opensips_registrar_default_expires{}`:                              3600,
+			`opensips_usrloc_registered_users{}`:                                20,
+			`opensips_usrloc_users{domain=location}`:                            20,
+			`opensips_usrloc_contacts{domain=location}`:                         22,
+			`opensips_usrloc_expired_contacts_total{domain=location}`:           5,
+			`opensips_net_waiting_bytes{transport=udp}`:                         0,
+		},
+	},
+	{
+		fixture: "get_statistics-3.2.json",
+		version: 302,
+		uptime:  7200,
+		want: map[string]float64{
+			`opensips_core_uptime_seconds_total{}`:                                  7200,
+			`opensips_load_load_1m{}`:                                               4,
+			`opensips_load_load_all_10m{}`:                                          2,
+			`opensips_load_process_load_1m{id=1}`:                                   6,
+			`opensips_load_process_load_10m{id=1}`:                                  4,
+			`opensips_shmem_group_used_size_bytes{group=default}`:                   524288,
+			`opensips_shmem_group_fragments{group=default}`:                         4,
+			`opensips_shmem_used_size_bytes{}`:                                      1048576,
+			`opensips_tm_inuse_transactions{}`:                                      3,
+			`opensips_dialog_replication_messages_sent_total{operation=delete}`:     26,
+			`opensips_dialog_replication_messages_received_total{operation=create}`: 7,
+			`opensips_registrar_accepted_registrations_total{}`:                     25,
+			`opensips_usrloc_contacts{domain=location}`:                             22,
+		},
+	},
+	{
+		// Stats of newer versions are not exported for older ones
+		fixture: "get_statistics-3.2.json",
+		version: 204,
+		uptime:  7200,
+		want: map[string]float64{
+			`opensips_load_load{}`:       3,
+			`opensips_shmem_fragments{}`: 12,
+		},
+		absent: []string{
+			`opensips_load_load_1m{}`,
+			`opensips_load_process_load_1m{id=1}`,
+			`opensips_shmem_group_used_size_bytes{group=default}`,
+		},
+	},
+	{
+		// Unknown versions export everything
+		fixture: "get_statistics-3.2.json",
+		version: 0,
+		uptime:  7200,
+		want: map[string]float64{
+			`opensips_load_load_1m{}`:                             4,
+			`opensips_shmem_group_used_size_bytes{group=default}`: 524288,
+		},
+	},
+	{
+		fixture: "get_statistics-2.4.json",
+		version: 204,
+		processes: []process{
+			newProcess("0", "100", "attendant"),
+			newProcess("1", "101", "SIP receiver udp:127.0.0.1:5060"),
+			newProcess("2", "102", "SIP receiver udp:127.0.0.1:5060"),
+		},
+		uptime: 3600,
+		want: map[string]float64{
+			`opensips_pkmem_used_size_bytes{id=1,role=SIP receiver,type=SIP receiver udp:127.0.0.1:5060}`: 131072,
+			`opensips_pkmem_role_used_size_bytes{role=SIP receiver}`:                                      229376,
+			`opensips_pkmem_role_used_size_bytes{role=attendant}`:                                         65536,
+			`opensips_load_role_process_load{role=SIP receiver}`:                                          3,
+		},
+		absent: []string{
+			`opensips_pkmem_used_size_bytes{id=1}`,
+		},
+	},
+}
+
+func TestCollectStats(t *testing.T) {
+	for _, test := range collectStatsTests {
+		metrics, uptime := collectStatsFixture(t, test.fixture, test.version, test.processes)
+		if uptime != test.uptime {
+			t.Errorf("%s@%d: uptime %v, want %v", test.fixture, test.version, uptime, test.uptime)
+		}
+		for key, want := range test.want {
+			got, exists := metrics[key]
+			if !exists {
+				t.Errorf("%s@%d: %s not exported", test.fixture, test.version, key)
+			} else if got != want {
+				t.Errorf("%s@%d: %s = %v, want %v", test.fixture, test.version, key, got, want)
+			}
+		}
+		for _, key := range test.absent {
+			if _, exists := metrics[key]; exists {
+				t.Errorf("%s@%d: %s exported", test.fixture, test.version, key)
+			}
+		}
+	}
+}
+
+func TestStatSupports(t *testing.T) {
+	tests := []struct {
+		since   int
+		version int
+		want    bool
+	}{
+		{0, 0, true},
+		{0, 204, true},
+		{300, 0, true},
+		{300, 204, false},
+		{300, 300, true},
+		{300, 302, true},
+	}
+	for _, test := range tests {
+		s := stat{since: test.since}
+		if got := s.supports(test.version); got != test.want {
+			t.Errorf("since %d: supports(%d) = %v, want %v", test.since, test.version, got, test.want)
+		}
+	}
+}
+
+// Every stat name must be matched by a single catalog entry per version.
+func TestStatsCatalogUnique(t *testing.T) {
+	for _, version := range []int{204, 302} {
+		for subsys, stats := range opensipsStats {
+			seen := make(map[string]bool)
+			for _, stat := range stats {
+				if stat.stat == "" || !stat.supports(version) {
+					continue
+				}
+				if seen[stat.stat] {
+					t.Errorf("%d: %s:%s listed twice", version, subsys, stat.stat)
+				}
+				seen[stat.stat] = true
+			}
+		}
+	}
+}
+
+// Stats aggregated by process role need their own help, without the process id.
+func TestStatsCatalogRoleHelp(t *testing.T) {
+	for subsys, stats := range opensipsStats {
+		for _, stat := range stats {
+			if stat.aggregate == noAggregation {
+				continue
+			}
+			if stat.roleHelp == "" || strings.Contains(stat.roleHelp, "#id") {
+				t.Errorf("%s:%s: role help %q", subsys, stat.name, stat.roleHelp)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A status report group and identifier
+type statusReportKey struct {
+	group      string
+	identifier string
+}
+
+// Status report collector, based on sr_list_status and sr_list_reports
+type statusReportCollector struct {
+	// Time of the last report seen for each identifier
+	lastReport map[statusReportKey]float64
+	newReports map[statusReportKey]float64
+
+	ready      *prometheus.Desc
+	status     *prometheus.Desc
+	reportsNew *prometheus.Desc
+}
+
+func init() {
+	registerCollector("status-report", "Export the readiness of the status report identifiers, listed by sr_list_status.",
+		[]string{"sr_list_status"}, newStatusReportCollector)
+}
+
+func newStatusReportCollector() (collector, error) {
+	return &statusReportCollector{
+		newReports: make(map[statusReportKey]float64),
+
+		ready: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "status_report", "ready"),
+			"1 if the status report identifier is ready",
+			[]string{"group", "identifier"},
+			nil,
+		),
+		status: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "status_report", "status"),
+			"Status code of the status report identifier",
+			[]string{"group", "identifier"},
+			nil,
+		),
+		reportsNew: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "status_report", "reports_total"),
+			"Total number of report entries logged since the exporter started",
+			[]string{"group", "identifier"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *statusReportCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.ready
+	ch <- c.status
+	ch <- c.reportsNew
+}
+
+// Call fn with every identifier of the status report groups listed in resp.
+func walkStatusReports(resp *opensips_mi.MINode, fn func(key statusReportKey, node *opensips_mi.MINode)) {
+	groups := childList(resp, "Groups")
+	if groups == nil {
+		groups = nodeList(resp)
+	}
+
+	for _, groupNode := range groups {
+		group := nodeField(groupNode, "Group", "Name")
+		identifiers := childList(groupNode, "Identifiers")
+		if identifiers == nil {
+			// Groups with a single identifier may be reported inline
+			fn(statusReportKey{group: group}, groupNode)
+			continue
+		}
+		for _, node := range identifiers {
+			fn(statusReportKey{group: group, identifier: nodeValue(node, "Name")}, node)
+		}
+	}
+}
+
+func (c *statusReportCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command("sr_list_status")
+	if err != nil {
+		return err
+	}
+
+	walkStatusReports(resp, func(key statusReportKey, node *opensips_mi.MINode) {
+		if readiness := node.Get("Readiness"); readiness != "" {
+			ch <- prometheus.MustNewConstMetric(c.ready, prometheus.GaugeValue, parseFlag(readiness), key.group, key.identifier)
+		}
+		if status, err := strconv.ParseFloat(node.Get("Status"), 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.status, prometheus.GaugeValue, status, key.group, key.identifier)
+		}
+	})
+
+	if !t.commands["sr_list_reports"] {
+		return nil
+	}
+
+	resp, err = t.conn.Command("sr_list_reports")
+	if err != nil {
+		return err
+	}
+
+	lastReport := make(map[statusReportKey]float64)
+	walkStatusReports(resp, func(key statusReportKey, node *opensips_mi.MINode) {
+		previous, seen := c.lastReport[key]
+		last := previous
+		for _, report := range childList(node, "Reports") {
+			ts, ok := parseTimestamp(nodeField(report, "Timestamp", "Date"))
+			if !ok {
+				continue
+			}
+			// Reports already present on the first scrape are not new
+			if c.lastReport != nil && (!seen || ts > previous) {
+				c.newReports[key]++
+			}
+			if ts > last {
+				last = ts
+			}
+		}
+		lastReport[key] = last
+		if _, exists := c.newReports[key]; !exists {
+			c.newReports[key] = 0
+		}
+	})
+	c.lastReport = lastReport
+
+	for key, value := range c.newReports {
+		ch <- prometheus.MustNewConstMetric(c.reportsNew, prometheus.CounterValue, value, key.group, key.identifier)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tavyc/opensips_exporter/opensips_mi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var tcpRemoteIPsLimit = flag.Int("collector.tcp.remote-ips-limit", 10,
+	"Maximum number of remote IPs with the most connections to export. 0 exports none.")
+
+// TCP connection states, as numbered by OpenSIPS
+var tcpStates = map[string]string{
+	"-2": "error",
+	"-1": "bad",
+	"0":  "ok",
+	"1":  "init",
+	"2":  "eof",
+	"3":  "accept",
+	"4":  "connecting",
+	"5":  "connected",
+}
+
+var tcpTrackedBuckets = []float64{10, 60, 300, 900, 1800, 3600, 14400, 43200, 86400}
+
+// TCP based connections collector, based on list_tcp_conns
+type tcpCollector struct {
+	// Time each connection was first listed, as OpenSIPS only reports when it expires
+	firstSeen map[string]time.Time
+
+	connections *prometheus.Desc
+	tracked     *prometheus.Desc
+	remoteIPs   *prometheus.Desc
+}
+
+func init() {
+	registerCollector("tcp", "Export the TCP, TLS and WebSocket connections, listed by list_tcp_conns.",
+		[]string{"list_tcp_conns"}, newTCPCollector)
+}
+
+func newTCPCollector() (collector, error) {
+	return &tcpCollector{
+		firstSeen: make(map[string]time.Time),
+
+		connections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tcp", "connections"),
+			"Number of connections by protocol, state and local socket",
+			[]string{"proto", "state", "socket"},
+			nil,
+		),
+		tracked: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tcp", "connection_tracked_seconds"),
+			"Time since the exporter first listed the connections, bounded by its own uptime",
+			[]string{"proto"},
+			nil,
+		),
+		remoteIPs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tcp", "remote_ip_connections"),
+			"Number of connections of the remote IPs with the most connections",
+			[]string{"ip"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *tcpCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.tracked
+	ch <- c.remoteIPs
+}
+
+// Return the IP of an address reported as ip:port.
+func addressIP(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func (c *tcpCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	connections := newLabelCounts(3)
+	remoteIPs := newLabelCounts(1)
+	tracked := make(map[string]*constHistogram)
+	seen := make(map[string]time.Time, len(c.firstSeen))
+
+	err := t.stream(func(node *opensips_mi.MINode) error {
+		proto := strings.ToLower(nodeField(node, "Type", "Proto"))
+		state, exists := tcpStates[node.Get("State")]
+		if !exists {
+			state = "unknown"
+		}
+		local := nodeField(node, "Local", "Destination")
+		remote := nodeField(node, "Remote", "Source")
+
+		connections.add(1, proto, state, local)
+		remoteIPs.add(1, addressIP(remote))
+
+		key := nodeValue(node, "ID") + "\xff" + remote
+		first, exists := c.firstSeen[key]
+		if !exists {
+			first = now
+		}
+		seen[key] = first
+
+		histogram, exists := tracked[proto]
+		if !exists {
+			histogram = newConstHistogram(tcpTrackedBuckets)
+			tracked[proto] = histogram
+		}
+		histogram.observe(now.Sub(first).Seconds())
+		return nil
+	}, "list_tcp_conns")
+	if err != nil {
+		return err
+	}
+	c.firstSeen = seen
+
+	for _, value := range connections.top(0) {
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, value.count, value.labels...)
+	}
+	for proto, histogram := range tracked {
+		ch <- histogram.metric(c.tracked, proto)
+	}
+	if *tcpRemoteIPsLimit > 0 {
+		for _, value := range remoteIPs.top(*tcpRemoteIPsLimit) {
+			ch <- prometheus.MustNewConstMetric(c.remoteIPs, prometheus.GaugeValue, value.count, value.labels...)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var tlsReadFiles = flag.Bool("collector.tls.read-files", false,
+	"Read the certificate files listed by tls_list from disk, for domains not loaded from the database.")
+
+// TLS domain certificates collector, based on tls_list
+type tlsCollector struct {
+	expiry *prometheus.Desc
+}
+
+func init() {
+	registerCollector("tls", "Export the expiry of the TLS domain certificates, listed by tls_list.",
+		[]string{"tls_list"}, newTLSCollector)
+}
+
+func newTLSCollector() (collector, error) {
+	return &tlsCollector{
+		expiry: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "certificate_expiry_timestamp_seconds"),
+			"Expiry time of the TLS domain certificate since unix epoch in seconds",
+			[]string{"domain", "type", "subject"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *tlsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.expiry
+}
+
+// Parse the PEM encoded certificates of a TLS domain.
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
+
+func (c *tlsCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command("tls_list")
+	if err != nil {
+		return err
+	}
+
+	exported := make(map[string]bool)
+	for _, node := range childList(resp, "Domains", "DOMAIN", "domains") {
+		domain := nodeValue(node, "name")
+		typ := strings.ToLower(node.Get("type"))
+		certificate := node.Get("certificate")
+
+		// Certificates loaded from the database are listed inline,
+		// the others by the path of their file
+		data := []byte(certificate)
+		if !strings.Contains(certificate, "-----BEGIN") {
+			if !*tlsReadFiles || certificate == "" {
+				continue
+			}
+			if data, err = ioutil.ReadFile(certificate); err != nil {
+				log.Printf("error reading the certificate of TLS domain %s: %s", domain, err)
+				continue
+			}
+		}
+
+		for _, cert := range parseCertificates(data) {
+			subject := cert.Subject.String()
+			key := domain + "\xff" + typ + "\xff" + subject
+			if exported[key] {
+				continue
+			}
+			exported[key] = true
+			ch <- prometheus.MustNewConstMetric(c.expiry, prometheus.GaugeValue,
+				float64(cert.NotAfter.Unix()), domain, typ, subject)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registrations collector of the uac_registrant module, based on reg_list
+type uacRegistrantCollector struct {
+	up           *prometheus.Desc
+	expiry       *prometheus.Desc
+	lastRegister *prometheus.Desc
+}
+
+func init() {
+	registerCollector("uac-registrant", "Export the state of the uac_registrant registrations, listed by reg_list.",
+		[]string{"reg_list"}, newUacRegistrantCollector)
+}
+
+func newUacRegistrantCollector() (collector, error) {
+	return &uacRegistrantCollector{
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "uac_registrant", "registration_up"),
+			"1 if the registration is registered with the registrar",
+			[]string{"aor", "registrar", "state"},
+			nil,
+		),
+		expiry: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "uac_registrant", "registration_expiry_seconds"),
+			"Time until the registration expires",
+			[]string{"aor", "registrar"},
+			nil,
+		),
+		lastRegister: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "uac_registrant", "last_register_timestamp_seconds"),
+			"Time of the last REGISTER sent since unix epoch in seconds",
+			[]string{"aor", "registrar"},
+			nil,
+		),
+	}, nil
+}
+
+func (c *uacRegistrantCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.expiry
+	ch <- c.lastRegister
+}
+
+func (c *uacRegistrantCollector) Collect(t *target, ch chan<- prometheus.Metric) error {
+	resp, err := t.conn.Command("reg_list")
+	if err != nil {
+		return err
+	}
+
+	now := float64(time.Now().Unix())
+	exported := make(map[string]bool)
+	for _, node := range childList(resp, "AOR", "Records") {
+		aor := nodeValue(node, "AOR")
+		registrar := node.Get("registrar")
+		key := aor + "\xff" + registrar
+		if exported[key] {
+			continue
+		}
+		exported[key] = true
+
+		// States are named like REGISTERED_STATE
+		state := strings.TrimSuffix(strings.ToLower(node.Get("state")), "_state")
+		up := 0.0
+		if state == "registered" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up, aor, registrar, state)
+
+		if timeout, ok := parseTimestamp(node.Get("registration_t_out")); ok && up == 1 {
+			ch <- prometheus.MustNewConstMetric(c.expiry, prometheus.GaugeValue, timeout-now, aor, registrar)
+		}
+		if sent, ok := parseTimestamp(node.Get("last_register_sent")); ok {
+			ch <- prometheus.MustNewConstMetric(c.lastRegister, prometheus.GaugeValue, sent, aor, registrar)
+		}
+	}
+
+	return nil
+}